@@ -0,0 +1,122 @@
+package db
+
+import "reflect"
+
+// 书签：标记一条记录，排序、过滤或增删其他记录后仍可用GotoBookmark回到该记录
+type Bookmark struct {
+	record map[string]interface{}
+	row    int //取书签时在Records中的下标（先按此下标查找）
+}
+
+// 当前记录的书签（没有当前记录时返回无效的书签）
+func (ds *DataSet) GetBookmark() Bookmark {
+	i := ds.recno()
+	if i < 0 {
+		return Bookmark{row: -1}
+	}
+	r := ds.row(i)
+	return Bookmark{record: ds.Records[r], row: r}
+}
+
+// 移到书签标记的记录，记录已删除或不可见（被过滤）时返回false
+func (ds *DataSet) GotoBookmark(b Bookmark) bool {
+	ds.checkBrowseMode()
+	r := ds.bookmarkRow(b)
+	if r < 0 {
+		return false
+	}
+	p := ds.position(r)
+	if p < 0 {
+		return false
+	}
+	ds.moveTo(p)
+	return true
+}
+
+// 书签标记的记录是否仍存在
+func (ds *DataSet) BookmarkValid(b Bookmark) bool {
+	return ds.bookmarkRow(b) >= 0
+}
+
+// 书签标记的记录在Records中的下标，不存在时返回-1
+func (ds *DataSet) bookmarkRow(b Bookmark) int {
+	if b.record == nil {
+		return -1
+	}
+	if b.row >= 0 && b.row < len(ds.Records) && sameRecord(ds.Records[b.row], b.record) {
+		return b.row
+	}
+	for r, rec := range ds.Records {
+		if sameRecord(rec, b.record) {
+			return r
+		}
+	}
+	return -1
+}
+
+// 是否为同一条记录（同一个map）
+func sameRecord(a, b map[string]interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+// 快照：复制可见的记录（按浏览次序）生成只读数据集，计算字段、查找字段按当时的值作为数据字段保存。
+// 快照的记录不再改变，可用Clone为每个goroutine生成各自的记录指针并发浏览。
+func (ds *DataSet) Snapshot() *DataSet {
+	ds.checkBrowseMode()
+	defs := ds.fieldDefs()
+	fields := make([]*FieldDef, len(defs))
+	for i, def := range defs {
+		fields[i] = dataFieldDef(def)
+	}
+	records := make([]map[string]interface{}, ds.recordCount)
+	for i := range records {
+		records[i] = copyRecord(ds.fullRecord(ds.record(i)))
+	}
+	snap := NewDataSet(fields, records)
+	snap.Fields = ds.Fields
+	snap.Dialect = ds.Dialect
+	snap.readOnly = true
+	return snap
+}
+
+// 复制记录指针：生成与本数据集共享记录（不复制记录）的只读数据集，记录指针、排序、过滤各自独立。
+// 快照(Snapshot)的Clone可在多个goroutine中并发使用；可修改的数据集的Clone与原数据集在同一goroutine中使用，
+// 原数据集增删记录后Clone看不到（字段值的修改可以看到）。事件(BeforeScroll等)不复制。
+func (ds *DataSet) Clone() *DataSet {
+	ds.checkBrowseMode()
+	c := &DataSet{
+		Fields:      ds.Fields,
+		FieldDefs:   append([]*FieldDef(nil), ds.FieldDefs...),
+		Records:     ds.Records,
+		RecIndex:    ds.RecIndex,
+		Dialect:     ds.Dialect,
+		recordCount: ds.recordCount,
+		sortKeys:    ds.sortKeys,
+		filter:      ds.filter,
+		view:        ds.view,
+		pos:         ds.pos,
+		readOnly:    true,
+	}
+	if !ds.readOnly {
+		//可修改的数据集增删记录时会移动Records中的元素，复制一份
+		c.Records = append([]map[string]interface{}(nil), ds.Records...)
+		c.indexDirty = true
+	}
+	if len(ds.indexes) > 0 {
+		c.indexes = make(map[string]*index, len(ds.indexes))
+		for name, idx := range ds.indexes {
+			if ds.readOnly {
+				c.indexes[name] = idx
+			} else {
+				c.indexes[name] = &index{keys: idx.keys}
+			}
+		}
+	}
+	if len(ds.virtual) > 0 {
+		c.virtual = make(map[string]CalcFunc, len(ds.virtual))
+		for name, fn := range ds.virtual {
+			c.virtual[name] = fn
+		}
+	}
+	return c
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"ninego/expr"
+)
+
+// 字段种类
+type FieldKind int
+
+const (
+	FieldData       FieldKind = iota //数据字段（保存在Records中）
+	FieldCalculated                  //计算字段（由函数或公式按同一记录的其他字段计算）
+	FieldLookup                      //查找字段（按关联字段在另一个数据集中查找）
+)
+
+// 计算字段的计算函数，record为当前记录（编辑中为编辑中的值），返回nil为空值
+type CalcFunc func(record map[string]interface{}) interface{}
+
+// 增加计算字段：读取时按同一记录的其他字段计算，不保存在Records中，ApplyUpdates也不写入数据库。
+// 可用Value、ValueAsString等读取，可排序、分组，导出时作为普通字段导出。
+func (ds *DataSet) AddCalcField(name string, typ FieldType, fn CalcFunc) error {
+	return ds.addVirtualField(&FieldDef{Name: name, Type: typ, Nullable: true, Kind: FieldCalculated}, fn)
+}
+
+// 增加由公式计算的计算字段（公式语法见expr，记录的字段名即变量名），例如"Price * Qty"。
+// 公式计算出错时字段值为空值，错误保存在ds.Error中。
+func (ds *DataSet) AddFormulaField(name string, typ FieldType, formula string) error {
+	prog, err := expr.Compile(formula)
+	if err != nil {
+		return err
+	}
+	return ds.AddCalcField(name, typ, func(record map[string]interface{}) interface{} {
+		val, err := prog.EvalValue(record)
+		if err == nil {
+			var v interface{}
+			if v, err = exprValue(val, typ); err == nil {
+				return v
+			}
+		}
+		ds.Error = fmt.Errorf("dataset: field %s: %w", name, err)
+		return nil
+	})
+}
+
+// 增加查找字段：按本数据集的keyFields在lookup数据集中查找lookupKeyFields值相同的第一条记录，取其resultField的值，
+// 例如ds.AddLookupField("CustomerName", "CustomerID", customers, "ID", "Name")。多个字段用";"分隔，按字符串比较（与Locate相同）。
+// 查找不受lookup数据集的排序、过滤影响，找不到时为空值。
+func (ds *DataSet) AddLookupField(name string, keyFields string, lookup *DataSet, lookupKeyFields string, resultField string) error {
+	keys, lkeys := splitKeys(keyFields), splitKeys(lookupKeyFields)
+	if len(keys) == 0 || len(keys) != len(lkeys) {
+		return fmt.Errorf("dataset: key fields %q do not match lookup key fields %q", keyFields, lookupKeyFields)
+	}
+	for _, key := range keys {
+		if !ds.hasField(key) {
+			return fmt.Errorf("dataset: field %s not found", key)
+		}
+	}
+	if !lookup.hasField(resultField) {
+		return fmt.Errorf("dataset: lookup field %s not found", resultField)
+	}
+	if err := lookup.AddIndex(lookupKeyFields); err != nil {
+		return err
+	}
+	def := &FieldDef{Name: name, Type: lookup.fieldType(resultField), Nullable: true, Kind: FieldLookup}
+	return ds.addVirtualField(def, func(record map[string]interface{}) interface{} {
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = ds.get(record, key)
+		}
+		if r := lookup.lookupRow(lkeys, values); r >= 0 {
+			return lookup.get(lookup.Records[r], resultField)
+		}
+		return nil
+	})
+}
+
+// 按索引在全部记录中查找（不受排序、过滤影响），返回Records中的下标，找不到时返回-1
+func (ds *DataSet) lookupRow(keys []string, values []interface{}) int {
+	ds.updateIndexes()
+	name := strings.Join(keys, ";")
+	idx := ds.indexes[name]
+	if idx == nil {
+		if ds.indexes == nil {
+			ds.indexes = map[string]*index{}
+		}
+		idx = &index{keys: keys}
+		idx.build(ds.Records)
+		ds.indexes[name] = idx
+	}
+	if rows := idx.rows[indexKey(values)]; len(rows) > 0 {
+		return rows[0]
+	}
+	return -1
+}
+
+func (ds *DataSet) addVirtualField(def *FieldDef, fn CalcFunc) error {
+	if def.Name == "" || fn == nil {
+		return fmt.Errorf("dataset: invalid field definition")
+	}
+	if ds.FieldDef(def.Name) != nil {
+		return fmt.Errorf("dataset: field %s already exists", def.Name)
+	}
+	//没有字段定义时先按记录生成，以免数据字段被当作不存在
+	ds.FieldDefs = ds.fieldDefs()
+	ds.FieldDefs = append(ds.FieldDefs, def)
+	if ds.virtual == nil {
+		ds.virtual = map[string]CalcFunc{}
+	}
+	ds.virtual[def.Name] = fn
+	if ds.viewActive() {
+		ds.keepCursor(ds.buildView)
+	}
+	return nil
+}
+
+// 删除计算字段或查找字段
+func (ds *DataSet) RemoveField(name string) error {
+	if ds.virtual[name] == nil {
+		return fmt.Errorf("dataset: %s is not a calculated or lookup field", name)
+	}
+	delete(ds.virtual, name)
+	for i, def := range ds.FieldDefs {
+		if def.Name == name {
+			ds.FieldDefs = append(ds.FieldDefs[:i], ds.FieldDefs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// 记录的字段值（计算字段、查找字段在此计算）
+func (ds *DataSet) get(record map[string]interface{}, name string) interface{} {
+	if fn := ds.virtual[name]; fn != nil {
+		return fn(record)
+	}
+	return record[name]
+}
+
+// 是否为计算字段或查找字段
+func (ds *DataSet) isVirtual(name string) bool {
+	return ds.virtual[name] != nil
+}
+
+// 含计算字段、查找字段的值的记录（用于过滤），没有计算字段时直接返回record
+func (ds *DataSet) fullRecord(record map[string]interface{}) map[string]interface{} {
+	if len(ds.virtual) == 0 {
+		return record
+	}
+	rec := copyRecord(record)
+	for name, fn := range ds.virtual {
+		rec[name] = fn(record)
+	}
+	return rec
+}
+
+// 复制字段定义，作为数据字段（用于由本数据集生成的新数据集）
+func dataFieldDef(def *FieldDef) *FieldDef {
+	d := *def
+	d.Kind = FieldData
+	return &d
+}
+
+// 将公式的计算结果转换为字段类型：数值按字段类型转换，字符串、布尔、日期按原值转换（字符串字段保持原文）
+func exprValue(val expr.Value, typ FieldType) (interface{}, error) {
+	switch val.Kind() {
+	case expr.NullKind:
+		return nil, nil
+	case expr.NumberKind:
+		d, _ := val.Decimal()
+		switch typ {
+		case FieldInteger:
+			return d.IntPart(), nil
+		case FieldFloat:
+			f, _ := d.Float64()
+			return f, nil
+		case FieldDecimal, FieldString:
+			return d.String(), nil
+		case FieldBoolean:
+			return !d.IsZero(), nil
+		}
+	case expr.BoolKind:
+		if typ.IsNumber() {
+			n := int64(0)
+			if val.Bool() {
+				n = 1
+			}
+			return convertValue(n, typ)
+		}
+	}
+	return convertValue(val.Interface(), typ)
+}
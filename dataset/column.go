@@ -0,0 +1,528 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 按列存放的字段值，按字段类型使用对应的切片（整数int64、浮点数float64、字符串string、
+// 二进制[]byte、日期时间time.Time、定点数decimal.Decimal、布尔bool），空值(NULL)另行标记
+type Column struct {
+	FieldDef
+	nulls  []bool
+	ints   []int64
+	floats []float64
+	strs   []string
+	bytes  [][]byte
+	times  []time.Time
+	decs   []decimal.Decimal
+	bools  []bool
+}
+
+func newColumn(def FieldDef, capacity int) *Column {
+	col := &Column{FieldDef: def, nulls: make([]bool, 0, capacity)}
+	switch def.Type {
+	case FieldInteger:
+		col.ints = make([]int64, 0, capacity)
+	case FieldFloat:
+		col.floats = make([]float64, 0, capacity)
+	case FieldDecimal:
+		col.decs = make([]decimal.Decimal, 0, capacity)
+	case FieldBoolean:
+		col.bools = make([]bool, 0, capacity)
+	case FieldDateTime:
+		col.times = make([]time.Time, 0, capacity)
+	case FieldBytes:
+		col.bytes = make([][]byte, 0, capacity)
+	default:
+		col.strs = make([]string, 0, capacity)
+	}
+	return col
+}
+
+// 记录条数
+func (col *Column) Len() int {
+	return len(col.nulls)
+}
+
+// 第i条记录的值是否为空(NULL)
+func (col *Column) IsNull(i int) bool {
+	return col.nulls[i]
+}
+
+// 第i条记录的值，类型与DataSet相同（见FieldType，定点数为string），空值为nil
+func (col *Column) Value(i int) interface{} {
+	if col.nulls[i] {
+		return nil
+	}
+	switch col.Type {
+	case FieldInteger:
+		return col.ints[i]
+	case FieldFloat:
+		return col.floats[i]
+	case FieldDecimal:
+		return col.decs[i].String()
+	case FieldBoolean:
+		return col.bools[i]
+	case FieldDateTime:
+		return col.times[i]
+	case FieldBytes:
+		return col.bytes[i]
+	}
+	return col.strs[i]
+}
+
+// 扫描用的目标（按字段类型）
+func (col *Column) scanDest() interface{} {
+	switch col.Type {
+	case FieldInteger:
+		return new(sql.NullInt64)
+	case FieldFloat:
+		return new(sql.NullFloat64)
+	case FieldBoolean:
+		return new(sql.NullBool)
+	case FieldDateTime:
+		return new(sql.NullTime)
+	case FieldBytes:
+		return new([]byte)
+	}
+	return new(sql.NullString)
+}
+
+// 追加扫描得到的值
+func (col *Column) appendScanned(dest interface{}) error {
+	null := false
+	switch x := dest.(type) {
+	case *sql.NullInt64:
+		null = !x.Valid
+		col.ints = append(col.ints, x.Int64)
+	case *sql.NullFloat64:
+		null = !x.Valid
+		col.floats = append(col.floats, x.Float64)
+	case *sql.NullBool:
+		null = !x.Valid
+		col.bools = append(col.bools, x.Bool)
+	case *sql.NullTime:
+		null = !x.Valid
+		col.times = append(col.times, x.Time)
+	case *[]byte:
+		null = *x == nil
+		col.bytes = append(col.bytes, *x)
+		*x = nil
+	case *sql.NullString:
+		null = !x.Valid
+		if col.Type == FieldDecimal {
+			d := decimal.Zero
+			if x.Valid {
+				var err error
+				if d, err = decimal.NewFromString(x.String); err != nil {
+					return fmt.Errorf("dataset: cannot parse %q as decimal", x.String)
+				}
+			}
+			col.decs = append(col.decs, d)
+		} else {
+			col.strs = append(col.strs, x.String)
+		}
+	}
+	col.nulls = append(col.nulls, null)
+	return nil
+}
+
+// 追加一个值（按字段类型转换）
+func (col *Column) append(v interface{}) error {
+	v, err := convertValue(v, col.Type)
+	if err != nil {
+		return fmt.Errorf("dataset: field %s: %w", col.Name, err)
+	}
+	col.nulls = append(col.nulls, v == nil)
+	switch col.Type {
+	case FieldInteger:
+		x, _ := v.(int64)
+		col.ints = append(col.ints, x)
+	case FieldFloat:
+		x, _ := v.(float64)
+		col.floats = append(col.floats, x)
+	case FieldDecimal:
+		d := decimal.Zero
+		if v != nil {
+			d, _ = decimal.NewFromString(v.(string))
+		}
+		col.decs = append(col.decs, d)
+	case FieldBoolean:
+		x, _ := v.(bool)
+		col.bools = append(col.bools, x)
+	case FieldDateTime:
+		x, _ := v.(time.Time)
+		col.times = append(col.times, x)
+	case FieldBytes:
+		x, _ := v.([]byte)
+		col.bytes = append(col.bytes, x)
+	default:
+		x, _ := v.(string)
+		col.strs = append(col.strs, x)
+	}
+	return nil
+}
+
+// 列式数据集：按字段类型分列存放记录，每列一个切片，不为每条记录分配map。
+// 浏览方法与DataSet相同，另有IsNull及返回(值, ok)的ValueInt64等方法；需要编辑、排序时用DataSet()转换。
+type ColumnSet struct {
+	Error    error
+	Fields   []*sql.ColumnType
+	Columns  []*Column
+	RecIndex int //-1=Bof, 0..N-1=记录, N=Eof
+
+	names map[string]int //字段名 -> Columns中的下标
+	count int
+}
+
+// 读取全部记录，按sql.ColumnType确定的字段类型分列存放
+func NewColumnSet(rows *sql.Rows) (*ColumnSet, error) {
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	cs := newColumnSet(fieldDefs(cols), 0)
+	cs.Fields = cols
+	dest := make([]interface{}, len(cs.Columns))
+	for i, col := range cs.Columns {
+		dest[i] = col.scanDest()
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("dataset: record %d: %w", cs.count, err)
+		}
+		for i, col := range cs.Columns {
+			if err := col.appendScanned(dest[i]); err != nil {
+				return nil, fmt.Errorf("dataset: record %d field %s: %w", cs.count, col.Name, err)
+			}
+		}
+		cs.count++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func newColumnSet(defs []*FieldDef, capacity int) *ColumnSet {
+	cs := &ColumnSet{RecIndex: -1, names: make(map[string]int, len(defs))}
+	for i, def := range defs {
+		cs.Columns = append(cs.Columns, newColumn(*dataFieldDef(def), capacity))
+		cs.names[def.Name] = i
+	}
+	return cs
+}
+
+// 由DataSet生成列式数据集（按浏览次序，值按字段类型转换）
+func (ds *DataSet) ColumnSet() (*ColumnSet, error) {
+	cs := newColumnSet(ds.fieldDefs(), ds.recordCount)
+	for i := 0; i < ds.recordCount; i++ {
+		rec := ds.record(i)
+		for _, col := range cs.Columns {
+			if err := col.append(ds.get(rec, col.Name)); err != nil {
+				return nil, fmt.Errorf("dataset: record %d: %w", i, err)
+			}
+		}
+		cs.count++
+	}
+	return cs, nil
+}
+
+// 转换为DataSet（可编辑、排序、过滤、导出等）
+func (cs *ColumnSet) DataSet() *DataSet {
+	defs := make([]*FieldDef, len(cs.Columns))
+	for i, col := range cs.Columns {
+		def := col.FieldDef
+		defs[i] = &def
+	}
+	records := make([]map[string]interface{}, cs.count)
+	for r := range records {
+		rec := make(map[string]interface{}, len(cs.Columns))
+		for _, col := range cs.Columns {
+			rec[col.Name] = col.Value(r)
+		}
+		records[r] = rec
+	}
+	ds := NewDataSet(defs, records)
+	ds.Fields = cs.Fields
+	return ds
+}
+
+// 按字段名取列，不存在时返回nil
+func (cs *ColumnSet) Column(name string) *Column {
+	if i, ok := cs.names[name]; ok {
+		return cs.Columns[i]
+	}
+	return nil
+}
+
+func (cs *ColumnSet) RecordCount() int {
+	return cs.count
+}
+
+func (cs *ColumnSet) IsEmpty() bool {
+	return cs.count == 0
+}
+
+func (cs *ColumnSet) recno() int {
+	switch {
+	case cs.count == 0:
+		return -1
+	case cs.RecIndex < 0:
+		return 0
+	case cs.RecIndex >= cs.count:
+		return cs.count - 1
+	}
+	return cs.RecIndex
+}
+
+func (cs *ColumnSet) Row(i int) *ColumnSet {
+	if i >= -1 && i <= cs.count {
+		cs.RecIndex = i
+	}
+	return cs
+}
+
+func (cs *ColumnSet) Eof() bool {
+	return cs.count == 0 || cs.RecIndex >= cs.count
+}
+
+func (cs *ColumnSet) Bof() bool {
+	return cs.count == 0 || cs.RecIndex < 0
+}
+
+func (cs *ColumnSet) First() {
+	cs.RecIndex = -1
+}
+
+func (cs *ColumnSet) Last() {
+	cs.RecIndex = cs.count
+}
+
+func (cs *ColumnSet) Next() bool {
+	if cs.RecIndex+1 >= cs.count {
+		cs.RecIndex = cs.count
+		return false
+	}
+	cs.RecIndex++
+	return true
+}
+
+func (cs *ColumnSet) Prior() bool {
+	if cs.RecIndex <= 0 {
+		return false
+	}
+	cs.RecIndex--
+	return true
+}
+
+func (cs *ColumnSet) FieldCount() int {
+	return len(cs.Columns)
+}
+
+// 当前记录的列及记录下标，无当前记录或字段不存在时col为nil
+func (cs *ColumnSet) cell(name string) (col *Column, i int) {
+	i = cs.recno()
+	if i < 0 {
+		return nil, -1
+	}
+	return cs.Column(name), i
+}
+
+// 字段值（类型与DataSet相同），空值为nil
+func (cs *ColumnSet) Value(name string) interface{} {
+	if col, i := cs.cell(name); col != nil {
+		return col.Value(i)
+	}
+	return nil
+}
+
+// 字段值是否为空(NULL)，字段不存在或无当前记录时也返回true
+func (cs *ColumnSet) IsNull(name string) bool {
+	col, i := cs.cell(name)
+	return col == nil || col.nulls[i]
+}
+
+func (cs *ColumnSet) ValueAsString(name string) string {
+	return asString(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueAsInteger(name string) int {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldInteger {
+		return int(col.ints[i])
+	}
+	return asInteger(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueAsFloat(name string) float64 {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldFloat {
+		return col.floats[i]
+	}
+	return asFloat(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueAsBoolean(name string) bool {
+	return asBoolean(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueAsDateTime(name string) time.Time {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldDateTime {
+		return col.times[i]
+	}
+	return asDateTime(cs.Value(name))
+}
+
+// 以下方法返回(值, ok)，值为空(NULL)或不能转换时ok为false
+
+func (cs *ColumnSet) ValueInt64(name string) (int64, bool) {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldInteger {
+		return col.ints[i], !col.nulls[i]
+	}
+	return valueInt64(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueFloat64(name string) (float64, bool) {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldFloat {
+		return col.floats[i], !col.nulls[i]
+	}
+	return valueFloat64(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueString(name string) (string, bool) {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldString {
+		return col.strs[i], !col.nulls[i]
+	}
+	return valueString(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueBytes(name string) ([]byte, bool) {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldBytes {
+		return col.bytes[i], !col.nulls[i]
+	}
+	return valueBytes(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueTime(name string) (time.Time, bool) {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldDateTime {
+		return col.times[i], !col.nulls[i]
+	}
+	return valueTime(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueDecimal(name string) (decimal.Decimal, bool) {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldDecimal {
+		return col.decs[i], !col.nulls[i]
+	}
+	return valueDecimal(cs.Value(name))
+}
+
+func (cs *ColumnSet) ValueBool(name string) (bool, bool) {
+	if col, i := cs.cell(name); col != nil && col.Type == FieldBoolean {
+		return col.bools[i], !col.nulls[i]
+	}
+	return valueBool(cs.Value(name))
+}
+
+// 字段值是否为空(NULL)，字段不存在或无当前记录时也返回true
+func (ds *DataSet) IsNull(name string) bool {
+	return ds.Value(name) == nil
+}
+
+func (ds *DataSet) ValueInt64(name string) (int64, bool) {
+	return valueInt64(ds.Value(name))
+}
+
+func (ds *DataSet) ValueFloat64(name string) (float64, bool) {
+	return valueFloat64(ds.Value(name))
+}
+
+func (ds *DataSet) ValueString(name string) (string, bool) {
+	return valueString(ds.Value(name))
+}
+
+func (ds *DataSet) ValueBytes(name string) ([]byte, bool) {
+	return valueBytes(ds.Value(name))
+}
+
+func (ds *DataSet) ValueTime(name string) (time.Time, bool) {
+	return valueTime(ds.Value(name))
+}
+
+func (ds *DataSet) ValueDecimal(name string) (decimal.Decimal, bool) {
+	return valueDecimal(ds.Value(name))
+}
+
+func (ds *DataSet) ValueBool(name string) (bool, bool) {
+	return valueBool(ds.Value(name))
+}
+
+// 按类型转换字段值，空值或不能转换时ok为false
+func valueInt64(v interface{}) (int64, bool) {
+	x, err := convertValue(v, FieldInteger)
+	if x == nil || err != nil {
+		return 0, false
+	}
+	return x.(int64), true
+}
+
+func valueFloat64(v interface{}) (float64, bool) {
+	x, err := convertValue(v, FieldFloat)
+	if x == nil || err != nil {
+		return 0, false
+	}
+	return x.(float64), true
+}
+
+func valueString(v interface{}) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	return asString(v), true
+}
+
+func valueBytes(v interface{}) ([]byte, bool) {
+	switch x := v.(type) {
+	case []byte:
+		return x, true
+	case string:
+		return []byte(x), true
+	}
+	return nil, false
+}
+
+func valueTime(v interface{}) (time.Time, bool) {
+	x, err := convertValue(v, FieldDateTime)
+	if x == nil || err != nil {
+		return time.Time{}, false
+	}
+	return x.(time.Time), true
+}
+
+func valueDecimal(v interface{}) (decimal.Decimal, bool) {
+	switch x := v.(type) {
+	case decimal.Decimal:
+		return x, true
+	case float64:
+		return decimal.NewFromFloat(x), true
+	case float32:
+		return decimal.NewFromFloat32(x), true
+	}
+	x, err := convertValue(v, FieldDecimal)
+	if x == nil || err != nil {
+		return decimal.Zero, false
+	}
+	d, err := decimal.NewFromString(x.(string))
+	return d, err == nil
+}
+
+func valueBool(v interface{}) (bool, bool) {
+	x, err := convertValue(v, FieldBoolean)
+	if x == nil || err != nil {
+		return false, false
+	}
+	return x.(bool), true
+}
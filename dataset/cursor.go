@@ -0,0 +1,252 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// DataSet、Cursor及ColumnSet共同的浏览接口
+type Navigator interface {
+	Next() bool
+	Prior() bool
+	Eof() bool
+	Bof() bool
+	Value(name string) interface{}
+	ValueAsString(name string) string
+	ValueAsInteger(name string) int
+	ValueAsFloat(name string) float64
+	ValueAsBoolean(name string) bool
+	ValueAsDateTime(name string) time.Time
+}
+
+var (
+	_ Navigator = (*DataSet)(nil)
+	_ Navigator = (*Cursor)(nil)
+	_ Navigator = (*ColumnSet)(nil)
+)
+
+// 游标：边读取*sql.Rows边浏览，不把全部记录读入内存。
+// 只进游标只保留当前记录；分页游标每次读取pageSize条，并保留最近读取的至多2*pageSize条记录供Prior回退。
+type Cursor struct {
+	Error     error
+	Fields    []*sql.ColumnType
+	FieldDefs []*FieldDef
+
+	rows     *sql.Rows
+	columns  map[string]int  //字段名 -> 列下标
+	pageSize int             //每次读取的条数
+	keep     int             //保留的记录条数
+	window   [][]interface{} //已读取并保留的记录（按列下标存放）
+	base     int             //window[0]的记录号
+	recNo    int             //当前记录号，-1为Bof
+	done     bool            //rows已读完
+}
+
+// 只进游标（只能Next，Prior总是返回false）
+func NewCursor(rows *sql.Rows) *Cursor {
+	return newCursor(rows, 1, 1)
+}
+
+// 分页游标，每次读取pageSize条记录，可Prior回退到最近读取的2*pageSize条记录
+func NewPagedCursor(rows *sql.Rows, pageSize int) *Cursor {
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	return newCursor(rows, pageSize, 2*pageSize)
+}
+
+func newCursor(rows *sql.Rows, pageSize, keep int) *Cursor {
+	c := &Cursor{rows: rows, pageSize: pageSize, keep: keep, recNo: -1}
+	if rows == nil {
+		c.done = true
+		return c
+	}
+	var err error
+	if c.Fields, err = rows.ColumnTypes(); err != nil {
+		c.Error = err
+		c.Close()
+		return c
+	}
+	c.FieldDefs = fieldDefs(c.Fields)
+	c.columns = make(map[string]int, len(c.Fields))
+	for i, col := range c.Fields {
+		c.columns[col.Name()] = i
+	}
+	return c
+}
+
+// 读取下一页记录，没有记录时返回false（并关闭rows）
+func (c *Cursor) fetch() bool {
+	if c.done {
+		return false
+	}
+	n := 0
+	for n < c.pageSize && c.rows.Next() {
+		values := make([]interface{}, len(c.columns))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := c.rows.Scan(ptrs...); err != nil {
+			c.Error = fmt.Errorf("dataset: record %d: %w", c.base+len(c.window), err)
+			c.Close()
+			break
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		c.window = append(c.window, values)
+		n++
+	}
+	if n < c.pageSize && !c.done {
+		if err := c.rows.Err(); err != nil && c.Error == nil {
+			c.Error = err
+		}
+		c.Close()
+	}
+	//丢弃超出保留条数的旧记录
+	if extra := len(c.window) - c.keep; extra > 0 {
+		c.window = append(c.window[:0], c.window[extra:]...)
+		c.base += extra
+	}
+	return n > 0
+}
+
+// 移到下一条记录
+func (c *Cursor) Next() bool {
+	end := c.base + len(c.window)
+	if c.recNo+1 < end || c.fetch() {
+		c.recNo++
+		return true
+	}
+	c.recNo = end
+	return false
+}
+
+// 移到上一条记录，超出保留的记录时返回false
+func (c *Cursor) Prior() bool {
+	if c.recNo-1 < c.base || c.recNo-1 >= c.base+len(c.window) {
+		return false
+	}
+	c.recNo--
+	return true
+}
+
+// 已读完全部记录且在末记录之后
+func (c *Cursor) Eof() bool {
+	return c.done && c.recNo >= c.base+len(c.window)
+}
+
+// 在首记录之前（尚未Next）
+func (c *Cursor) Bof() bool {
+	return c.recNo < 0
+}
+
+// 当前记录号（从0开始）
+func (c *Cursor) RecNo() int {
+	return c.recNo
+}
+
+func (c *Cursor) current() []interface{} {
+	i := c.recNo - c.base
+	if i < 0 || i >= len(c.window) {
+		return nil
+	}
+	return c.window[i]
+}
+
+// 字段值（无当前记录或字段不存在时返回nil）
+func (c *Cursor) Value(name string) interface{} {
+	values := c.current()
+	if values == nil {
+		return nil
+	}
+	if i, ok := c.columns[name]; ok {
+		return values[i]
+	}
+	return nil
+}
+
+func (c *Cursor) ValueAsString(name string) string {
+	return asString(c.Value(name))
+}
+
+func (c *Cursor) ValueAsInteger(name string) int {
+	return asInteger(c.Value(name))
+}
+
+func (c *Cursor) ValueAsFloat(name string) float64 {
+	return asFloat(c.Value(name))
+}
+
+func (c *Cursor) ValueAsBoolean(name string) bool {
+	return asBoolean(c.Value(name))
+}
+
+func (c *Cursor) ValueAsDateTime(name string) time.Time {
+	return asDateTime(c.Value(name))
+}
+
+// 字段值是否为空(NULL)
+func (c *Cursor) IsNull(name string) bool {
+	return c.Value(name) == nil
+}
+
+func (c *Cursor) ValueInt64(name string) (int64, bool) {
+	return valueInt64(c.Value(name))
+}
+
+func (c *Cursor) ValueFloat64(name string) (float64, bool) {
+	return valueFloat64(c.Value(name))
+}
+
+func (c *Cursor) ValueString(name string) (string, bool) {
+	return valueString(c.Value(name))
+}
+
+func (c *Cursor) ValueBytes(name string) ([]byte, bool) {
+	return valueBytes(c.Value(name))
+}
+
+func (c *Cursor) ValueTime(name string) (time.Time, bool) {
+	return valueTime(c.Value(name))
+}
+
+func (c *Cursor) ValueDecimal(name string) (decimal.Decimal, bool) {
+	return valueDecimal(c.Value(name))
+}
+
+func (c *Cursor) ValueBool(name string) (bool, bool) {
+	return valueBool(c.Value(name))
+}
+
+func (c *Cursor) FieldCount() int {
+	return len(c.columns)
+}
+
+// 当前记录（复制为map，可用于expr公式计算等）
+func (c *Cursor) Record() map[string]interface{} {
+	values := c.current()
+	if values == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(values))
+	for name, i := range c.columns {
+		m[name] = values[i]
+	}
+	return m
+}
+
+// 关闭游标（读完全部记录时自动关闭）
+func (c *Cursor) Close() error {
+	c.done = true
+	if c.rows == nil {
+		return nil
+	}
+	return c.rows.Close()
+}
@@ -7,27 +7,54 @@ import (
 	"strings"
 	"time"
 
-	"github.com/axcom/ninego/skit"
+	"ninego/skit"
 )
 
 type DataSet struct {
-	Error    error
-	Fields   []*sql.ColumnType
-	Records  []map[string]interface{}
-	RecIndex int //recno -> -1=Bof, 0..N=recores, N+1=Eof
-
-	recordCount int
+	Error     error
+	Fields    []*sql.ColumnType
+	FieldDefs []*FieldDef //字段定义（由Fields生成，或由LoadFromCSV等生成）
+	Records   []map[string]interface{}
+	RecIndex  int     //recno -> -1=Bof, 0..N=recores, N+1=Eof（排序、过滤后为浏览次序）
+	Dialect   Dialect //ApplyUpdates生成SQL语句时的数据库方言
+
+	recordCount int //可见的记录条数
 	findKey     string
 	findValue   []interface{}
+
+	sortKeys   []sortField
+	filter     func(record map[string]interface{}) bool
+	view       []int //排序、过滤后的浏览次序（Records中的下标），nil为原始次序
+	pos        []int //Records中的下标 -> 浏览次序，不可见为-1
+	indexes    map[string]*index
+	indexDirty bool
+
+	state     DataSetState
+	buffer    map[string]interface{} //编辑/新增中的记录
+	editIndex int                    //编辑中的记录在Records中的下标（新增时为插入位置）
+	insertPos int                    //新增记录在浏览次序中的插入位置
+	status    []*rowStatus           //记录的修改状态，与Records按下标对应
+	deleted   []*rowStatus           //已删除的记录
+
+	link    *masterLink //主从关系（本数据集为明细）
+	details []*DataSet  //明细数据集
+
+	virtual  map[string]CalcFunc //计算字段、查找字段
+	readOnly bool                //只读（Snapshot、Clone）
+
+	BeforeScroll func(ds *DataSet)               //移动记录指针前调用
+	AfterScroll  func(ds *DataSet)               //移动记录指针后调用
+	OnChange     func(ds *DataSet, field string) //字段值改变(SetValue)或记录改变(Post、Cancel、Delete等，field为"")后调用
 }
 
 //DataSet数据集
 func New(records *sql.Rows) (ds *DataSet) {
 	ds = &DataSet{}
-	ds.Fields, _ = records.ColumnTypes()
 	ds.RecIndex = -1
 	ds.recordCount = 0
 	if records != nil {
+		ds.Fields, _ = records.ColumnTypes()
+		ds.FieldDefs = fieldDefs(ds.Fields)
 		var err error
 		ds.Records, err = Rows2mapObjects(records)
 		if err == nil {
@@ -50,8 +77,8 @@ func FetchFieldsFromRecord(oPtr interface{}, ds *DataSet) error {
 		return fmt.Errorf("Check type error not Struct")
 	}
 	b := false
-	for _, col := range ds.Fields {
-		name := col.Name()
+	for _, def := range ds.FieldDefs {
+		name := def.Name
 		if v, t, b = findField(v, t, name); b {
 			val := ds.Value(name)
 			if &val == nil {
@@ -168,50 +195,79 @@ func (ds *DataSet) RecordCount() int {
 	return ds.recordCount
 }
 
+//字段值（编辑/新增中取编辑中的值）
 func (ds *DataSet) Value(name string) interface{} {
-	return ds.Records[ds.recno()][name]
+	if ds.buffer != nil {
+		return ds.get(ds.buffer, name)
+	}
+	i := ds.recno()
+	if i < 0 {
+		return nil
+	}
+	return ds.get(ds.record(i), name)
 }
 
 func (ds *DataSet) ValueAsString(name string) string {
-	if ds.Records[ds.recno()][name] == nil {
+	return asString(ds.Value(name))
+}
+
+func (ds *DataSet) ValueAsInteger(name string) int {
+	return asInteger(ds.Value(name))
+}
+
+func (ds *DataSet) ValueAsFloat(name string) float64 {
+	return asFloat(ds.Value(name))
+}
+
+func (ds *DataSet) ValueAsBoolean(name string) bool {
+	return asBoolean(ds.Value(name))
+}
+
+func (ds *DataSet) ValueAsDateTime(name string) time.Time {
+	return asDateTime(ds.Value(name))
+}
+
+//字段值类型转换（DataSet与Cursor共用），空值转换为零值
+func asString(v interface{}) string {
+	if v == nil {
 		return ""
 	}
-	return skit.String(ds.Records[ds.recno()][name])
+	return skit.String(v)
 }
 
-func (ds *DataSet) ValueAsInteger(name string) int {
-	if ds.Records[ds.recno()][name] == nil {
+func asInteger(v interface{}) int {
+	if v == nil {
 		return 0
 	}
 	var ret int
-	skit.SetValue(&ret, ds.Records[ds.recno()][name])
+	skit.SetValue(&ret, v)
 	return ret
 }
 
-func (ds *DataSet) ValueAsFloat(name string) float64 {
-	if ds.Records[ds.recno()][name] == nil {
+func asFloat(v interface{}) float64 {
+	if v == nil {
 		return 0
 	}
 	var ret float64
-	skit.SetValue(&ret, ds.Records[ds.recno()][name])
+	skit.SetValue(&ret, v)
 	return ret
 }
 
-func (ds *DataSet) ValueAsBoolean(name string) bool {
-	if ds.Records[ds.recno()][name] == nil {
+func asBoolean(v interface{}) bool {
+	if v == nil {
 		return false
 	}
 	var ret bool
-	skit.SetValue(&ret, ds.Records[ds.recno()][name])
+	skit.SetValue(&ret, v)
 	return ret
 }
 
-func (ds *DataSet) ValueAsDateTime(name string) time.Time {
-	if ds.Records[ds.recno()][name] == nil {
+func asDateTime(v interface{}) time.Time {
+	if v == nil {
 		return time.Time{}
 	}
 	var ret time.Time
-	skit.SetValue(&ret, ds.Records[ds.recno()][name])
+	skit.SetValue(&ret, v)
 	return ret
 }
 
@@ -220,14 +276,28 @@ func (ds *DataSet) IsEmpty() bool {
 }
 
 func (ds *DataSet) Row(i int) *DataSet {
-	if i != ds.RecIndex {
-		if i >= -1 && i <= ds.recordCount {
-			ds.RecIndex = i
-		}
+	ds.checkBrowseMode()
+	if i >= -1 && i <= ds.recordCount {
+		ds.moveTo(i)
 	}
 	return ds
 }
 
+//移动记录指针（-1为Bof，recordCount为Eof），移动前后调用BeforeScroll、AfterScroll
+func (ds *DataSet) moveTo(i int) {
+	if i == ds.RecIndex {
+		return
+	}
+	if ds.BeforeScroll != nil {
+		ds.BeforeScroll(ds)
+	}
+	ds.RecIndex = i
+	ds.scrolled()
+	if ds.AfterScroll != nil {
+		ds.AfterScroll(ds)
+	}
+}
+
 func (ds *DataSet) Eof() bool {
 	return ds.IsEmpty() || ds.RecIndex >= ds.recordCount
 }
@@ -237,30 +307,33 @@ func (ds *DataSet) Bof() bool {
 }
 
 func (ds *DataSet) First() {
-	ds.RecIndex = -1
+	ds.checkBrowseMode()
+	ds.moveTo(-1)
 }
 
 func (ds *DataSet) Last() {
-	ds.RecIndex = ds.recordCount
+	ds.checkBrowseMode()
+	ds.moveTo(ds.recordCount)
 }
 
 func (ds *DataSet) Next() bool {
-	ds.RecIndex++
-	if ds.RecIndex >= ds.recordCount {
-		if ds.RecIndex > ds.recordCount {
-			ds.RecIndex--
+	ds.checkBrowseMode()
+	if ds.RecIndex+1 >= ds.recordCount {
+		if ds.RecIndex < ds.recordCount {
+			ds.moveTo(ds.recordCount)
 		}
 		return false
 	}
+	ds.moveTo(ds.RecIndex + 1)
 	return true
 }
 
 func (ds *DataSet) Prior() bool {
-	ds.RecIndex--
-	if ds.RecIndex < 0 {
-		ds.RecIndex++
+	ds.checkBrowseMode()
+	if ds.RecIndex <= 0 {
 		return false
 	}
+	ds.moveTo(ds.RecIndex - 1)
 	return true
 }
 
@@ -272,34 +345,45 @@ func (ds *DataSet) FieldCount() int {
 	if ds.RecIndex >= 0 && ds.RecIndex < ds.recordCount {
 		i = ds.RecIndex
 	}
-	return len(ds.Records[i])
+	return len(ds.record(i))
 }
 
-//从首记录开始查找
+//从首记录开始查找（已用AddIndex建立KeyFields索引时直接取索引）
 func (ds *DataSet) Locate(KeyFields string, Values ...interface{}) bool {
+	ds.checkBrowseMode() //先保存编辑中的记录，保存后重新排序、过滤会改变浏览次序
 	ds.findKey = KeyFields
 	ds.findValue = Values
+	if p, ok := ds.locateIndex(KeyFields, Values); ok {
+		if p >= 0 {
+			ds.Row(p)
+		}
+		return p >= 0
+	}
 	return ds.find(0, KeyFields, Values...)
 }
 
 //从指定记录开始向下查找
 func (ds *DataSet) find(recno int, KeyFields string, Values ...interface{}) bool {
+	ds.checkBrowseMode()
 	keys := strings.Split(KeyFields, ";")
 	n := len(keys)
 	if n > len(Values) {
 		n = len(Values)
 	}
+	if recno < 0 {
+		recno = 0
+	}
 	for i := recno; i < ds.recordCount; i++ {
 		count := 0
 		for k := 0; k < n; k++ {
-			if ds.Row(i).ValueAsString(keys[k]) == skit.String(Values[k]) {
+			if skit.String(ds.get(ds.record(i), keys[k])) == skit.String(Values[k]) {
 				count++
 			} else {
 				break
 			}
 		}
 		if count == n {
-			ds.RecIndex = i
+			ds.moveTo(i)
 			return true
 		}
 	}
@@ -320,6 +404,7 @@ func (ds *DataSet) FindNext() bool {
 
 //向前查找
 func (ds *DataSet) FindPrior() bool {
+	ds.checkBrowseMode()
 	keys := strings.Split(ds.findKey, ";")
 	n := len(keys)
 	if n > len(ds.findValue) {
@@ -328,14 +413,14 @@ func (ds *DataSet) FindPrior() bool {
 	for i := ds.RecIndex - 1; i >= 0; i-- {
 		count := 0
 		for k := 0; k < n; k++ {
-			if skit.String(ds.Records[i][keys[k]]) == skit.String(ds.findValue[k]) {
+			if skit.String(ds.get(ds.record(i), keys[k])) == skit.String(ds.findValue[k]) {
 				count++
 			} else {
 				break
 			}
 		}
 		if count == n {
-			ds.RecIndex = i
+			ds.moveTo(i)
 			return true
 		}
 	}
@@ -0,0 +1,1268 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 测试用的订单数据集：ID、Name、Amount
+func newOrders() *DataSet {
+	fields := []*FieldDef{
+		{Name: "ID", Type: FieldInteger},
+		{Name: "Name", Type: FieldString, Nullable: true},
+		{Name: "Amount", Type: FieldDecimal, Nullable: true},
+	}
+	return NewDataSet(fields, []map[string]interface{}{
+		{"ID": int64(1), "Name": "alice", "Amount": "10.50"},
+		{"ID": int64(2), "Name": "bob", "Amount": "7"},
+		{"ID": int64(3), "Name": nil, "Amount": nil},
+	})
+}
+
+func TestEdit(t *testing.T) {
+	ds := newOrders()
+	var changes []string
+	ds.OnChange = func(ds *DataSet, field string) { changes = append(changes, field) }
+
+	if err := ds.SetValue("Name", "x"); err != ErrNotEditing {
+		t.Errorf("SetValue in browse state = %v", err)
+	}
+	ds.Row(1)
+	if err := ds.Edit(); err != nil {
+		t.Fatal(err)
+	}
+	ds.SetValue("Name", "robert")
+	if got := ds.ValueAsString("Name"); got != "robert" {
+		t.Errorf("Value while editing = %q", got)
+	}
+	if err := ds.SetValue("Missing", 1); err == nil {
+		t.Error("SetValue(Missing) expected error")
+	}
+	if err := ds.Post(); err != nil {
+		t.Fatal(err)
+	}
+	if ds.UpdateStatus() != StatusModified || ds.OldValue("Name") != "bob" || ds.ValueAsString("Name") != "robert" {
+		t.Errorf("after Post: status %v, old %v, new %v", ds.UpdateStatus(), ds.OldValue("Name"), ds.Value("Name"))
+	}
+
+	//Post时值未改变不记为修改；改回原值时取消修改
+	ds.Row(0)
+	ds.Edit()
+	ds.SetValue("Name", "alice")
+	ds.Post()
+	if ds.UpdateStatus() != StatusUnmodified {
+		t.Errorf("unchanged Post: status %v", ds.UpdateStatus())
+	}
+	ds.Row(1)
+	ds.Edit()
+	ds.SetValue("Name", "bob")
+	ds.Post()
+	if ds.UpdateStatus() != StatusUnmodified || ds.ChangeCount() != 0 {
+		t.Errorf("reverted Post: status %v, %d changes", ds.UpdateStatus(), ds.ChangeCount())
+	}
+
+	//Cancel放弃修改
+	ds.Edit()
+	ds.SetValue("Name", "bobby")
+	ds.Cancel()
+	if ds.State() != StateBrowse || ds.ValueAsString("Name") != "bob" {
+		t.Errorf("after Cancel: state %v, name %v", ds.State(), ds.Value("Name"))
+	}
+
+	//Insert插入到当前记录之前，Append追加到末尾；移动记录指针时自动Post
+	if err := ds.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	ds.SetValue("ID", int64(4))
+	ds.Next()
+	if ds.State() != StateBrowse || ds.RecordCount() != 4 || ds.Records[1]["ID"] != int64(4) {
+		t.Errorf("Insert: state %v, count %d, records %v", ds.State(), ds.RecordCount(), ds.Records)
+	}
+	ds.Append()
+	ds.SetValue("ID", int64(5))
+	ds.Post()
+	if ds.RecIndex != 4 || ds.ValueAsInteger("ID") != 5 || ds.UpdateStatus() != StatusInserted {
+		t.Errorf("Append: RecIndex %d, ID %v, status %v", ds.RecIndex, ds.Value("ID"), ds.UpdateStatus())
+	}
+
+	//删除新增的记录不产生修改，删除原有的记录保留原值
+	ds.Delete()
+	ds.Row(0)
+	ds.Delete()
+	if ds.RecordCount() != 3 || ds.ValueAsInteger("ID") != 4 {
+		t.Errorf("Delete: count %d, current %v", ds.RecordCount(), ds.Value("ID"))
+	}
+	got := map[UpdateStatus]int{}
+	for _, ch := range ds.Changes() {
+		got[ch.Status]++
+	}
+	if got[StatusDeleted] != 1 || got[StatusModified] != 0 || got[StatusInserted] != 1 {
+		t.Errorf("Changes() = %v", got)
+	}
+	if ch := ds.Changes()[0]; ch.Status != StatusDeleted || ch.Original["Name"] != "alice" {
+		t.Errorf("deleted change = %+v", ch)
+	}
+	if len(changes) == 0 || changes[0] != "Name" {
+		t.Errorf("OnChange fields = %q", changes)
+	}
+
+	ds.CommitUpdates()
+	if ds.ChangeCount() != 0 {
+		t.Errorf("ChangeCount after CommitUpdates = %d", ds.ChangeCount())
+	}
+
+	//新增中Delete只取消新增的记录
+	ds.Row(1)
+	ds.Append()
+	ds.SetValue("ID", int64(9))
+	if err := ds.Delete(); err != nil || ds.State() != StateBrowse || ds.RecordCount() != 3 || ds.ChangeCount() != 0 {
+		t.Errorf("Delete while inserting: %v, state %v, count %d, %d changes", err, ds.State(), ds.RecordCount(), ds.ChangeCount())
+	}
+}
+
+func TestEditReadOnly(t *testing.T) {
+	ds := newOrders().Snapshot()
+	ds.First()
+	if err := ds.Edit(); err != ErrReadOnly {
+		t.Errorf("Edit = %v", err)
+	}
+	if err := ds.Append(); err != ErrReadOnly {
+		t.Errorf("Append = %v", err)
+	}
+	if err := ds.Insert(); err != ErrReadOnly {
+		t.Errorf("Insert = %v", err)
+	}
+	if err := ds.Delete(); err != ErrReadOnly {
+		t.Errorf("Delete = %v", err)
+	}
+	if ds.State() != StateBrowse || ds.RecordCount() != 3 {
+		t.Errorf("read-only dataset changed: state %v, count %d", ds.State(), ds.RecordCount())
+	}
+}
+
+// 测试用的数据库驱动：Query返回testDB中的记录，Exec记录执行的语句
+type testDB struct {
+	columns  []testColumn
+	rows     [][]driver.Value
+	affected func(query string) int64 //Exec影响的记录数
+
+	mu        sync.Mutex
+	execs     []string
+	args      [][]driver.Value
+	committed int
+	rollback  int
+}
+
+type testColumn struct {
+	name     string
+	dbType   string
+	scanType reflect.Type
+	nullable bool
+}
+
+var testDBs sync.Map //dsn -> *testDB
+
+func init() {
+	sql.Register("dstest", testDriver{})
+}
+
+func openTestDB(t *testing.T, db *testDB) *sql.DB {
+	t.Helper()
+	testDBs.Store(t.Name(), db)
+	conn, err := sql.Open("dstest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		testDBs.Delete(t.Name())
+	})
+	return conn
+}
+
+func queryTestDB(t *testing.T, db *testDB) *sql.Rows {
+	t.Helper()
+	rows, err := openTestDB(t, db).Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+type testDriver struct{}
+
+func (testDriver) Open(name string) (driver.Conn, error) {
+	db, ok := testDBs.Load(name)
+	if !ok {
+		return nil, errors.New("dstest: unknown database " + name)
+	}
+	return &testConn{db.(*testDB)}, nil
+}
+
+type testConn struct{ db *testDB }
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) { return &testStmt{c.db, query}, nil }
+func (c *testConn) Close() error                              { return nil }
+func (c *testConn) Begin() (driver.Tx, error)                 { return c, nil }
+
+func (c *testConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.committed++
+	return nil
+}
+
+func (c *testConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.rollback++
+	return nil
+}
+
+type testStmt struct {
+	db    *testDB
+	query string
+}
+
+func (s *testStmt) Close() error  { return nil }
+func (s *testStmt) NumInput() int { return -1 }
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.execs = append(s.db.execs, s.query)
+	s.db.args = append(s.db.args, args)
+	n := int64(1)
+	if s.db.affected != nil {
+		n = s.db.affected(s.query)
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &testRows{db: s.db}, nil
+}
+
+type testRows struct {
+	db *testDB
+	i  int
+}
+
+func (r *testRows) Columns() []string {
+	names := make([]string, len(r.db.columns))
+	for i, col := range r.db.columns {
+		names[i] = col.name
+	}
+	return names
+}
+
+func (r *testRows) Close() error { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.db.rows) {
+		return io.EOF
+	}
+	copy(dest, r.db.rows[r.i])
+	r.i++
+	return nil
+}
+
+func (r *testRows) ColumnTypeScanType(i int) reflect.Type   { return r.db.columns[i].scanType }
+func (r *testRows) ColumnTypeDatabaseTypeName(i int) string { return r.db.columns[i].dbType }
+func (r *testRows) ColumnTypeNullable(i int) (nullable, ok bool) {
+	return r.db.columns[i].nullable, true
+}
+
+// 修改、删除、新增各一条记录
+func editOrders(ds *DataSet) {
+	ds.Locate("ID", 2)
+	ds.Edit()
+	ds.SetValue("Amount", "8")
+	ds.Post()
+	ds.Locate("ID", 3)
+	ds.Delete()
+	ds.Append()
+	ds.SetValue("ID", int64(4))
+	ds.SetValue("Name", "carol")
+	ds.Post()
+}
+
+func TestUpdateStatements(t *testing.T) {
+	cases := []struct {
+		dialect Dialect
+		table   string
+		want    []string
+	}{
+		{MySQL, "orders", []string{
+			"DELETE FROM `orders` WHERE `ID` = ?",
+			"UPDATE `orders` SET `Amount` = ? WHERE `ID` = ?",
+			"INSERT INTO `orders` (`ID`, `Name`) VALUES (?, ?)",
+		}},
+		{Postgres, "sales.orders", []string{
+			`DELETE FROM "sales"."orders" WHERE "ID" = $1`,
+			`UPDATE "sales"."orders" SET "Amount" = $1 WHERE "ID" = $2`,
+			`INSERT INTO "sales"."orders" ("ID", "Name") VALUES ($1, $2)`,
+		}},
+		{SQLite, `my"table`, []string{
+			`DELETE FROM "my""table" WHERE "ID" = ?`,
+			`UPDATE "my""table" SET "Amount" = ? WHERE "ID" = ?`,
+			`INSERT INTO "my""table" ("ID", "Name") VALUES (?, ?)`,
+		}},
+	}
+	for _, c := range cases {
+		ds := newOrders()
+		ds.Dialect = c.dialect
+		editOrders(ds)
+		stmts, err := ds.UpdateStatements(c.table, "ID")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, stmt := range stmts {
+			got = append(got, stmt.SQL)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("dialect %d:\n got %q\nwant %q", c.dialect, got, c.want)
+		}
+		if args := stmts[1].Args; len(args) != 2 || args[0] != "8" || args[1] != int64(2) {
+			t.Errorf("dialect %d: UPDATE args = %v", c.dialect, args)
+		}
+	}
+
+	//主键为空值时用IS NULL定位
+	ds := NewDataSet([]*FieldDef{{Name: "K"}, {Name: "V"}}, []map[string]interface{}{{"K": nil, "V": "a"}})
+	ds.First()
+	ds.Delete()
+	if stmts, _ := ds.UpdateStatements("t", "K"); len(stmts) != 1 || stmts[0].SQL != "DELETE FROM `t` WHERE `K` IS NULL" {
+		t.Errorf("NULL key: %+v", stmts)
+	}
+	if _, err := ds.UpdateStatements("t", "Missing"); err == nil {
+		t.Error("UpdateStatements with unknown key expected error")
+	}
+	if _, err := ds.UpdateStatements(" ", "K"); err == nil {
+		t.Error("UpdateStatements without table expected error")
+	}
+
+	//新增的记录全部为空值时插入默认值
+	for dialect, want := range map[Dialect]string{MySQL: "INSERT INTO `t` () VALUES ()", Postgres: `INSERT INTO "t" DEFAULT VALUES`, SQLite: `INSERT INTO "t" DEFAULT VALUES`} {
+		ds := NewDataSet([]*FieldDef{{Name: "K"}, {Name: "V"}}, nil)
+		ds.Dialect = dialect
+		ds.Append()
+		ds.Post()
+		if stmts, _ := ds.UpdateStatements("t", "K"); len(stmts) != 1 || stmts[0].SQL != want || len(stmts[0].Args) != 0 {
+			t.Errorf("dialect %d: all-NULL insert %+v", dialect, stmts)
+		}
+	}
+}
+
+func TestApplyUpdates(t *testing.T) {
+	db := &testDB{}
+	ds := newOrders()
+	editOrders(ds)
+	if err := ds.ApplyUpdates(openTestDB(t, db), "orders", "ID"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.execs) != 3 || db.committed != 1 || db.rollback != 0 || ds.ChangeCount() != 0 {
+		t.Errorf("execs %d, committed %d, rollback %d, changes %d", len(db.execs), db.committed, db.rollback, ds.ChangeCount())
+	}
+
+	//修改的记录不存在时回滚，返回*UpdateError，保留待保存的修改
+	db = &testDB{affected: func(query string) int64 {
+		if strings.HasPrefix(query, "UPDATE") {
+			return 0
+		}
+		return 1
+	}}
+	ds = newOrders()
+	editOrders(ds)
+	err := ds.ApplyUpdates(openTestDB(t, db), "orders", "ID")
+	var ue *UpdateError
+	if !errors.As(err, &ue) || len(ue.Conflicts) != 1 || ue.Conflicts[0].Statement.Change.Original["Amount"] != "7" {
+		t.Fatalf("ApplyUpdates conflict = %v", err)
+	}
+	if db.committed != 0 || db.rollback != 1 || ds.ChangeCount() != 3 {
+		t.Errorf("committed %d, rollback %d, changes %d", db.committed, db.rollback, ds.ChangeCount())
+	}
+}
+
+func TestSortFilter(t *testing.T) {
+	ds := newOrders()
+	ids := func() []int {
+		var got []int
+		for ds.First(); ds.Next(); {
+			got = append(got, ds.ValueAsInteger("ID"))
+		}
+		return got
+	}
+	ds.Next()
+	if err := ds.Sort("Amount DESC"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("Sort(Amount DESC) = %v", got) //按数值比较，空值最小
+	}
+	ds.Sort("Name")
+	if got := ids(); !reflect.DeepEqual(got, []int{3, 1, 2}) {
+		t.Errorf("Sort(Name) = %v", got)
+	}
+	if err := ds.Sort("Name UP"); err == nil {
+		t.Error("Sort(Name UP) expected error")
+	}
+	if err := ds.Filter("Amount > 5"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []int{1, 2}) || ds.RecordCount() != 2 || len(ds.Records) != 3 {
+		t.Errorf("Filter = %v, RecordCount %d", got, ds.RecordCount())
+	}
+	if err := ds.Filter("Amount >"); err == nil {
+		t.Error("Filter with syntax error expected error")
+	}
+	ds.Filter("")
+	ds.Sort("")
+	if got := ids(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("original order = %v", got)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	ds := newOrders()
+	if err := ds.AddIndex("Missing"); err == nil {
+		t.Error("AddIndex(Missing) expected error")
+	}
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			ds.AddIndex("Name")
+		}
+		if !ds.Locate("Name", "bob") || ds.ValueAsInteger("ID") != 2 {
+			t.Errorf("indexed %v: Locate(bob) at %v", indexed, ds.Value("ID"))
+		}
+		if ds.Locate("Name", "nobody") {
+			t.Errorf("indexed %v: Locate(nobody) = true", indexed)
+		}
+	}
+
+	//索引在修改记录后更新
+	ds.Locate("Name", "bob")
+	ds.Edit()
+	ds.SetValue("Name", "zed")
+	ds.Post()
+	if ds.Locate("Name", "bob") || !ds.Locate("Name", "zed") {
+		t.Error("index not rebuilt after Post")
+	}
+
+	//编辑中Locate：先保存，再按保存后的浏览次序查找
+	ds.Sort("Name")
+	ds.Locate("Name", "alice")
+	ds.Edit()
+	ds.SetValue("Name", "zz")
+	if !ds.Locate("Name", "zed") || ds.ValueAsInteger("ID") != 2 {
+		t.Errorf("Locate while editing: ID %v", ds.Value("ID"))
+	}
+	if ds.State() != StateBrowse || !ds.Locate("Name", "zz") || ds.ValueAsInteger("ID") != 1 {
+		t.Errorf("edited record not posted: state %v, ID %v", ds.State(), ds.Value("ID"))
+	}
+
+	ds.Sort("ID")
+	if !ds.Find("Amount", "7") || ds.ValueAsInteger("ID") != 2 || ds.FindNext() {
+		t.Error("Find/FindNext")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	ds := newOrders()
+	ds.Records[0]["Name"] = "a, \"quoted\" name"
+	ds.Records[1]["Name"] = ""
+	var buf bytes.Buffer
+	if err := ds.SaveToCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "ID,Name,Amount\n1,\"a, \"\"quoted\"\" name\",10.50\n2,\"\",7\n3,,\n"
+	if buf.String() != want {
+		t.Errorf("SaveToCSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	//空字段为NULL，""为空字符串；未定义的字段推断类型
+	got, err := LoadFromCSV(&buf, &FieldDef{Name: "Amount", Type: FieldDecimal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Records, ds.Records) {
+		t.Errorf("round trip:\n got %v\nwant %v", got.Records, ds.Records)
+	}
+	if typ := got.FieldDef("ID").Type; typ != FieldInteger {
+		t.Errorf("inferred ID type = %v", typ)
+	}
+
+	//有前导0的列保留为字符串
+	got, err = LoadFromCSV(strings.NewReader("Zip,Code,Rate,When\n007,10,0.5,2024-01-02\n010,-3,1e3,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]FieldType{"Zip": FieldString, "Code": FieldInteger, "Rate": FieldFloat, "When": FieldDateTime}
+	for name, typ := range types {
+		if got.FieldDef(name).Type != typ {
+			t.Errorf("inferred %s type = %v, want %v", name, got.FieldDef(name).Type, typ)
+		}
+	}
+	if got.Records[0]["Zip"] != "007" || got.Records[1]["When"] != nil {
+		t.Errorf("records = %v", got.Records)
+	}
+
+	//只有true/false推断为布尔，单字母及0/1不推断为布尔
+	got, err = LoadFromCSV(strings.NewReader("Flag,OK,Bit\nT,true,1\nF,FALSE,0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types = map[string]FieldType{"Flag": FieldString, "OK": FieldBoolean, "Bit": FieldInteger}
+	for name, typ := range types {
+		if got.FieldDef(name).Type != typ {
+			t.Errorf("inferred %s type = %v, want %v", name, got.FieldDef(name).Type, typ)
+		}
+	}
+
+	if _, err := LoadFromCSV(strings.NewReader("A\n\"open")); err == nil {
+		t.Error("unterminated quote expected error")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	when := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	fields := []*FieldDef{
+		{Name: "ID", Type: FieldInteger},
+		{Name: "Price", Type: FieldDecimal, Nullable: true},
+		{Name: "Paid", Type: FieldBoolean},
+		{Name: "At", Type: FieldDateTime, Nullable: true},
+		{Name: "Data", Type: FieldBytes, Nullable: true},
+	}
+	ds := NewDataSet(fields, []map[string]interface{}{
+		{"ID": int64(1), "Price": "12345678901234567890.123", "Paid": true, "At": when, "Data": []byte{0, 1, 2}},
+		{"ID": int64(2), "Price": nil, "Paid": false, "At": nil, "Data": nil},
+	})
+	var buf bytes.Buffer
+	if err := ds.SaveToJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"Price":12345678901234567890.123`) {
+		t.Errorf("decimal not written as exact number: %s", buf.String())
+	}
+	got, err := LoadFromJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.FieldDefs, fields) {
+		t.Errorf("fields = %v", got.FieldDefs)
+	}
+	if at, _ := got.Records[0]["At"].(time.Time); !at.Equal(when) {
+		t.Errorf("At = %v", got.Records[0]["At"])
+	}
+	got.Records[0]["At"] = when
+	if !reflect.DeepEqual(got.Records, ds.Records) {
+		t.Errorf("round trip:\n got %v\nwant %v", got.Records, ds.Records)
+	}
+
+	//不含字段定义的记录数组按数据推断类型
+	got, err = LoadFromJSON(strings.NewReader(`[{"a": 1, "b": "x"}, {"a": 2.5, "b": null}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FieldDef("a").Type != FieldFloat || got.FieldDef("b").Type != FieldString || got.Records[0]["a"] != 1.0 {
+		t.Errorf("inferred: %v %v", got.FieldDefs, got.Records)
+	}
+}
+
+func TestSpreadsheetML(t *testing.T) {
+	ds := newOrders()
+	ds.Records[0]["Name"] = "<a&b>"
+	var buf bytes.Buffer
+	if err := ds.SaveToSpreadsheetML(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<Worksheet ss:Name="Sheet1">`,
+		`<Cell ss:StyleID="h"><Data ss:Type="String">Amount</Data></Cell>`,
+		`<Cell><Data ss:Type="Number">1</Data></Cell><Cell><Data ss:Type="String">&lt;a&amp;b&gt;</Data></Cell><Cell><Data ss:Type="Number">10.50</Data></Cell>`,
+		`<Cell><Data ss:Type="Number">3</Data></Cell><Cell/><Cell/>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("SaveToSpreadsheetML missing %s\n%s", want, out)
+		}
+	}
+}
+
+// 测试用的查询结果：ID(INT)、Name(VARCHAR)、Price(DECIMAL)，共n条记录
+func newTestRows(n int) *testDB {
+	db := &testDB{columns: []testColumn{
+		{"ID", "INT", reflect.TypeOf(int64(0)), false},
+		{"Name", "VARCHAR", reflect.TypeOf(sql.NullString{}), true},
+		{"Price", "DECIMAL", reflect.TypeOf(sql.RawBytes{}), true},
+	}}
+	for i := 1; i <= n; i++ {
+		var name driver.Value
+		if i%2 == 1 {
+			name = []byte("item" + strconv.Itoa(i))
+		}
+		db.rows = append(db.rows, []driver.Value{int64(i), name, []byte(strconv.Itoa(i) + ".50")})
+	}
+	return db
+}
+
+func TestNew(t *testing.T) {
+	ds := New(queryTestDB(t, newTestRows(3)))
+	if ds.RecordCount() != 3 || len(ds.FieldDefs) != 3 {
+		t.Fatalf("RecordCount %d, fields %d", ds.RecordCount(), len(ds.FieldDefs))
+	}
+	types := []FieldType{FieldInteger, FieldString, FieldDecimal}
+	for i, def := range ds.FieldDefs {
+		if def.Type != types[i] {
+			t.Errorf("field %s type %v, want %v", def.Name, def.Type, types[i])
+		}
+	}
+	ds.Row(0)
+	if ds.Value("Name") != "item1" || ds.ValueAsFloat("Price") != 1.5 {
+		t.Errorf("first record = %v", ds.Records[0])
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := NewCursor(queryTestDB(t, newTestRows(3)))
+	if !c.Bof() || c.Eof() || c.FieldCount() != 3 {
+		t.Errorf("new cursor: Bof %v, Eof %v, FieldCount %d", c.Bof(), c.Eof(), c.FieldCount())
+	}
+	var ids []int
+	for c.Next() {
+		ids = append(ids, c.ValueAsInteger("ID"))
+		if c.Prior() {
+			t.Error("forward-only cursor Prior = true")
+		}
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3}) || !c.Eof() || c.Error != nil || c.Next() {
+		t.Errorf("ids %v, Eof %v, Error %v", ids, c.Eof(), c.Error)
+	}
+	if c.Value("ID") != nil || c.Record() != nil {
+		t.Error("value after Eof")
+	}
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPagedCursor(t *testing.T) {
+	c := NewPagedCursor(queryTestDB(t, newTestRows(7)), 2)
+	defer c.Close()
+	for i := 0; i < 5; i++ {
+		c.Next()
+	}
+	//已读取3页(6条)，保留最近的4条（记录号2..5）
+	if c.RecNo() != 4 || c.ValueAsInteger("ID") != 5 {
+		t.Fatalf("RecNo %d, ID %v", c.RecNo(), c.Value("ID"))
+	}
+	if v, ok := c.ValueString("Name"); !ok || v != "item5" {
+		t.Errorf("ValueString(Name) = %q, %v", v, ok)
+	}
+	var back []int
+	for c.Prior() {
+		back = append(back, c.ValueAsInteger("ID"))
+	}
+	if !reflect.DeepEqual(back, []int{4, 3}) || c.RecNo() != 2 {
+		t.Errorf("Prior = %v, RecNo %d", back, c.RecNo())
+	}
+	var rest []int
+	for c.Next() {
+		rest = append(rest, c.ValueAsInteger("ID"))
+		if rec := c.Record(); rec["ID"] != int64(rest[len(rest)-1]) {
+			t.Errorf("Record() = %v", rec)
+		}
+	}
+	if !reflect.DeepEqual(rest, []int{4, 5, 6, 7}) || !c.Eof() {
+		t.Errorf("Next = %v, Eof %v", rest, c.Eof())
+	}
+	if !c.Prior() || c.ValueAsInteger("ID") != 7 || !c.Prior() {
+		t.Fatalf("Prior after Eof: ID %v", c.Value("ID"))
+	}
+	if !c.IsNull("Name") || c.ValueAsString("Price") != "6.50" {
+		t.Errorf("record 6: Name %v, Price %v", c.Value("Name"), c.Value("Price"))
+	}
+}
+
+func TestColumnSet(t *testing.T) {
+	cs, err := NewColumnSet(queryTestDB(t, newTestRows(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.RecordCount() != 3 || cs.Column("Price").Type != FieldDecimal || cs.Column("Missing") != nil {
+		t.Fatalf("RecordCount %d, columns %v", cs.RecordCount(), cs.Columns)
+	}
+	if col := cs.Column("ID"); col.Len() != 3 || col.Value(2) != int64(3) {
+		t.Errorf("ID column: len %d, value %v", col.Len(), col.Value(2))
+	}
+
+	cs.Row(1)
+	if !cs.IsNull("Name") || cs.Value("Name") != nil || cs.ValueAsString("Name") != "" {
+		t.Errorf("NULL Name: %v", cs.Value("Name"))
+	}
+	if _, ok := cs.ValueString("Name"); ok {
+		t.Error("ValueString(NULL) ok")
+	}
+	if d, ok := cs.ValueDecimal("Price"); !ok || d.String() != "2.5" {
+		t.Errorf("ValueDecimal = %v, %v", d, ok)
+	}
+	if n, ok := cs.ValueInt64("ID"); !ok || n != 2 {
+		t.Errorf("ValueInt64 = %v, %v", n, ok)
+	}
+	if _, ok := cs.ValueTime("Price"); ok {
+		t.Error("ValueTime(Price) ok")
+	}
+	var ids []int
+	for cs.First(); cs.Next(); {
+		ids = append(ids, cs.ValueAsInteger("ID"))
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3}) || !cs.Eof() {
+		t.Errorf("Next = %v", ids)
+	}
+
+	//与DataSet互相转换（按浏览次序）
+	ds := cs.DataSet()
+	if ds.RecordCount() != 3 || ds.Records[0]["Price"] != "1.5" || ds.Records[1]["Name"] != nil {
+		t.Errorf("DataSet() records = %v", ds.Records)
+	}
+	ds.Sort("ID DESC")
+	cs2, err := ds.ColumnSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs2.Column("ID").Value(0) != int64(3) || !cs2.Column("Name").IsNull(1) {
+		t.Errorf("ColumnSet() from sorted DataSet: %v", cs2.Column("ID").Value(0))
+	}
+
+	//DataSet的IsNull及(值, ok)方法
+	ds.First()
+	ds.Next()
+	if ds.IsNull("ID") || !ds.IsNull("Missing") {
+		t.Error("DataSet.IsNull")
+	}
+	if b, ok := ds.ValueBool("ID"); !ok || !b {
+		t.Errorf("ValueBool(ID) = %v, %v", b, ok)
+	}
+}
+
+func TestStructs(t *testing.T) {
+	type Base struct {
+		ID int64 `db:"ID"`
+	}
+	type Order struct {
+		Base
+		Name    *string         `json:"name"`
+		Amount  decimal.Decimal `db:"Amount"`
+		Note    sql.NullString
+		Price   decimal.NullDecimal
+		Skipped string `db:"-"`
+	}
+	ds := newOrders()
+	ds.FieldDefs = append(ds.FieldDefs, &FieldDef{Name: "Note", Nullable: true}, &FieldDef{Name: "Price", Type: FieldDecimal, Nullable: true}, &FieldDef{Name: "Extra"})
+	ds.Records[0]["Note"], ds.Records[0]["Price"] = "n1", "1.25"
+	ds.Sort("ID DESC")
+
+	orders, err := ToStructs[*Order](ds)
+	var ue *UnmappedError
+	if !errors.As(err, &ue) || !reflect.DeepEqual(ue.Fields, []string{"Extra"}) {
+		t.Fatalf("ToStructs error = %v", err)
+	}
+	if len(orders) != 3 || orders[0].ID != 3 || orders[2].ID != 1 {
+		t.Fatalf("ToStructs order = %+v", orders)
+	}
+	//空值：指针为nil，decimal.Decimal为零值，sql.NullString等Valid为false
+	o := orders[0]
+	if o.Name != nil || !o.Amount.IsZero() || o.Note.Valid || o.Price.Valid {
+		t.Errorf("NULL fields = %+v", o)
+	}
+	o = orders[2]
+	if o.Name == nil || *o.Name != "alice" || o.Amount.String() != "10.5" || o.Note.String != "n1" || o.Price.Decimal.String() != "1.25" {
+		t.Errorf("record 1 = %+v", o)
+	}
+
+	if _, err := ToStructs[int](ds); err == nil {
+		t.Error("ToStructs[int] expected error")
+	}
+	bad := NewDataSet([]*FieldDef{{Name: "ID"}}, []map[string]interface{}{{"ID": "x"}})
+	if _, err := ToStructs[Order](bad); err == nil {
+		t.Error("ToStructs with invalid integer expected error")
+	}
+
+	//FromStructs按结构体字段生成字段定义，nil元素忽略
+	back, err := FromStructs([]*Order{orders[2], nil, orders[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]FieldType{"ID": FieldInteger, "name": FieldString, "Amount": FieldDecimal, "Note": FieldString, "Price": FieldDecimal}
+	if len(back.FieldDefs) != len(types) {
+		t.Errorf("FromStructs fields = %v", back.FieldDefs)
+	}
+	for _, def := range back.FieldDefs {
+		if def.Type != types[def.Name] {
+			t.Errorf("field %s type %v", def.Name, def.Type)
+		}
+	}
+	want := []map[string]interface{}{
+		{"ID": int64(1), "name": "alice", "Amount": "10.5", "Note": "n1", "Price": "1.25"},
+		{"ID": int64(3), "name": nil, "Amount": "0", "Note": nil, "Price": nil},
+	}
+	if !reflect.DeepEqual(back.Records, want) {
+		t.Errorf("FromStructs records:\n got %v\nwant %v", back.Records, want)
+	}
+}
+
+// 测试用的订单明细：OrderID、Line、Name、Qty
+func newLines() *DataSet {
+	fields := []*FieldDef{
+		{Name: "OrderID", Type: FieldInteger, Nullable: true},
+		{Name: "Line", Type: FieldInteger},
+		{Name: "Name", Type: FieldString},
+		{Name: "Qty", Type: FieldInteger},
+	}
+	return NewDataSet(fields, []map[string]interface{}{
+		{"OrderID": int64(1), "Line": int64(1), "Name": "pen", "Qty": int64(2)},
+		{"OrderID": int64(2), "Line": int64(1), "Name": "ink", "Qty": int64(1)},
+		{"OrderID": int64(1), "Line": int64(2), "Name": "pad", "Qty": int64(5)},
+		{"OrderID": nil, "Line": int64(1), "Name": "lost", "Qty": int64(9)},
+	})
+}
+
+func TestMasterDetail(t *testing.T) {
+	orders, lines := newOrders(), newLines()
+	if err := lines.SetMaster(orders, "ID", "OrderID;Line"); err == nil {
+		t.Error("SetMaster with mismatched fields expected error")
+	}
+	if err := lines.SetMaster(orders, "ID", "OrderID"); err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.SetMaster(lines, "OrderID", "ID"); err == nil {
+		t.Error("circular SetMaster expected error")
+	}
+	names := func() []string {
+		var got []string
+		for lines.First(); lines.Next(); {
+			got = append(got, lines.ValueAsString("Name"))
+		}
+		return got
+	}
+	want := [][]string{{"pen", "pad"}, {"ink"}, nil}
+	for i := 0; orders.Next(); i++ {
+		if got := names(); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("order %v: lines %v, want %v", orders.Value("ID"), got, want[i])
+		}
+	}
+
+	//明细新增记录时关联字段取主数据集的值
+	orders.Locate("ID", 2)
+	lines.Append()
+	lines.SetValue("Line", int64(2))
+	lines.Post()
+	if lines.RecordCount() != 2 || lines.ValueAsInteger("OrderID") != 2 {
+		t.Errorf("Append in detail: count %d, OrderID %v", lines.RecordCount(), lines.Value("OrderID"))
+	}
+	if lines.Master() != orders {
+		t.Error("Master()")
+	}
+	lines.SetMaster(nil, "", "")
+	if lines.RecordCount() != 5 || lines.Master() != nil {
+		t.Errorf("after SetMaster(nil): count %d", lines.RecordCount())
+	}
+}
+
+func TestJoin(t *testing.T) {
+	orders, lines := newOrders(), newLines()
+	if _, err := Join(orders, lines, "ID=Missing", InnerJoin); err == nil {
+		t.Error("Join on unknown field expected error")
+	}
+	inner, err := Join(orders, lines, "ID=OrderID", InnerJoin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, def := range inner.FieldDefs {
+		names = append(names, def.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"ID", "Name", "Amount", "OrderID", "Line", "Name_1", "Qty"}) {
+		t.Errorf("Join fields = %v", names)
+	}
+	if inner.RecordCount() != 3 || inner.Records[1]["Name_1"] != "pad" || inner.Records[2]["Name"] != "bob" {
+		t.Errorf("InnerJoin records = %v", inner.Records)
+	}
+
+	//左连接：没有对应记录时右边字段为空值；空值不与任何记录连接
+	left, err := Join(lines, orders, "OrderID=ID", LeftJoin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left.RecordCount() != 4 || left.Records[3]["Name_1"] != nil || left.Records[3]["ID"] != nil || !left.FieldDef("Amount").Nullable {
+		t.Errorf("LeftJoin records = %v", left.Records)
+	}
+
+	//同名连接字段只保留左边的
+	same := NewDataSet([]*FieldDef{{Name: "ID", Type: FieldInteger}, {Name: "Tag"}}, []map[string]interface{}{{"ID": int64(3), "Tag": "x"}})
+	joined, _ := Join(orders, same, "ID", InnerJoin)
+	if len(joined.FieldDefs) != 4 || joined.RecordCount() != 1 || joined.Records[0]["Tag"] != "x" {
+		t.Errorf("Join on same name: %v %v", joined.FieldDefs, joined.Records)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	fields := []*FieldDef{
+		{Name: "Region", Type: FieldString, Nullable: true},
+		{Name: "Month", Type: FieldInteger},
+		{Name: "Qty", Type: FieldInteger, Nullable: true},
+		{Name: "Amount", Type: FieldDecimal},
+	}
+	ds := NewDataSet(fields, []map[string]interface{}{
+		{"Region": "east", "Month": int64(1), "Qty": int64(2), "Amount": "1.10"},
+		{"Region": "west", "Month": int64(2), "Qty": int64(3), "Amount": "2.20"},
+		{"Region": "east", "Month": int64(2), "Qty": nil, "Amount": "3.30"},
+		{"Region": nil, "Month": int64(1), "Qty": int64(1), "Amount": "4"},
+		{"Region": "", "Month": int64(1), "Qty": int64(5), "Amount": "5"},
+	})
+	g, err := ds.GroupBy("Region", "SUM(Qty);SUM(Amount) AS Total;COUNT(*);COUNT(Qty);AVG(Qty);MAX(Month)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//空值(NULL)与空字符串为不同的分组
+	want := []map[string]interface{}{
+		{"Region": "east", "SUM_Qty": int64(2), "Total": "4.4", "COUNT": int64(2), "COUNT_Qty": int64(1), "AVG_Qty": 2.0, "MAX_Month": int64(2)},
+		{"Region": "west", "SUM_Qty": int64(3), "Total": "2.2", "COUNT": int64(1), "COUNT_Qty": int64(1), "AVG_Qty": 3.0, "MAX_Month": int64(2)},
+		{"Region": nil, "SUM_Qty": int64(1), "Total": "4", "COUNT": int64(1), "COUNT_Qty": int64(1), "AVG_Qty": 1.0, "MAX_Month": int64(1)},
+		{"Region": "", "SUM_Qty": int64(5), "Total": "5", "COUNT": int64(1), "COUNT_Qty": int64(1), "AVG_Qty": 5.0, "MAX_Month": int64(1)},
+	}
+	if !reflect.DeepEqual(g.Records, want) {
+		t.Errorf("GroupBy:\n got %v\nwant %v", g.Records, want)
+	}
+	if g.FieldDef("Total").Type != FieldDecimal || g.FieldDef("AVG_Qty").Type != FieldFloat || g.FieldDef("COUNT").Type != FieldInteger {
+		t.Errorf("GroupBy field types: %v", g.FieldDefs)
+	}
+	for _, aggs := range []string{"SUM(Region)", "MEDIAN(Qty)", "SUM(*)", "SUM(Missing)", "SUM(Qty);SUM(Qty)", "MAX(Month) AS Region"} {
+		if _, err := ds.GroupBy("Region", aggs); err == nil {
+			t.Errorf("GroupBy(%s) expected error", aggs)
+		}
+	}
+
+	//只统计可见的记录
+	ds.Filter("Month = 2")
+	if g, _ := ds.GroupBy("Month", "COUNT(*)"); g.RecordCount() != 1 || g.Records[0]["COUNT"] != int64(2) {
+		t.Errorf("GroupBy filtered = %v", g.Records)
+	}
+	ds.Filter("")
+
+	//Pivot：列按值排序，空值列名为"NULL"，与空字符串列分开
+	p, err := ds.Pivot("Month", "Region", "Qty", "sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, def := range p.FieldDefs {
+		names = append(names, def.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"Month", "NULL", "", "east", "west"}) {
+		t.Errorf("Pivot columns = %q", names)
+	}
+	want = []map[string]interface{}{
+		{"Month": int64(1), "NULL": int64(1), "": int64(5), "east": int64(2), "west": nil},
+		{"Month": int64(2), "NULL": nil, "": nil, "east": nil, "west": int64(3)},
+	}
+	if !reflect.DeepEqual(p.Records, want) {
+		t.Errorf("Pivot:\n got %v\nwant %v", p.Records, want)
+	}
+	//Locate仍按字符串比较，空值与空字符串相同（有无索引结果相同）
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			ds.AddIndex("Region")
+		}
+		if !ds.Locate("Region", "") || ds.ValueAsInteger("Qty") != 1 || !ds.Locate("Region", nil) || ds.ValueAsInteger("Qty") != 1 {
+			t.Errorf("indexed %v: Locate NULL/empty Region", indexed)
+		}
+	}
+}
+
+func TestCalcFields(t *testing.T) {
+	ds := newOrders()
+	if err := ds.AddCalcField("Upper", FieldString, func(rec map[string]interface{}) interface{} {
+		if rec["Name"] == nil {
+			return nil
+		}
+		return strings.ToUpper(rec["Name"].(string))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	formulas := []struct {
+		name    string
+		typ     FieldType
+		formula string
+	}{
+		{"Double", FieldDecimal, "Amount * 2"},
+		{"Whole", FieldInteger, "Amount * 1"},
+		{"Code", FieldString, "'00' & ID"},
+		{"Sci", FieldString, "'1e3'"},
+		{"Flag", FieldString, "ID > 1"},
+		{"Big", FieldBoolean, "Amount - 7"},
+		{"Positive", FieldInteger, "ID > 1"},
+	}
+	for _, f := range formulas {
+		if err := ds.AddFormulaField(f.name, f.typ, f.formula); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ds.AddFormulaField("Bad", FieldInteger, "Amount *"); err == nil {
+		t.Error("AddFormulaField with syntax error expected error")
+	}
+	if err := ds.AddCalcField("Name", FieldString, func(map[string]interface{}) interface{} { return nil }); err == nil {
+		t.Error("AddCalcField with existing name expected error")
+	}
+
+	//字符串结果保持原文，数值结果按字段类型转换
+	ds.Row(0)
+	want := map[string]interface{}{
+		"Upper": "ALICE", "Double": "21", "Whole": int64(10), "Code": "001", "Sci": "1e3",
+		"Flag": "false", "Big": true, "Positive": int64(0),
+	}
+	for name, v := range want {
+		if got := ds.Value(name); got != v {
+			t.Errorf("%s = %#v, want %#v", name, got, v)
+		}
+	}
+	ds.Row(1)
+	if ds.Value("Big") != false || ds.Value("Positive") != int64(1) || ds.Value("Flag") != "true" {
+		t.Errorf("record 2: Big %v, Positive %v, Flag %v", ds.Value("Big"), ds.Value("Positive"), ds.Value("Flag"))
+	}
+	ds.Row(2)
+	if ds.Value("Double") != "0" || ds.Value("Upper") != nil || ds.Error != nil {
+		t.Errorf("record 3: Double %v, Upper %v, Error %v", ds.Value("Double"), ds.Value("Upper"), ds.Error)
+	}
+
+	//计算字段可排序、过滤，不能赋值，编辑中按编辑中的值计算
+	ds.Sort("Double DESC")
+	ds.First()
+	ds.Next()
+	if ds.ValueAsInteger("ID") != 1 {
+		t.Errorf("Sort by calculated field: first ID %v", ds.Value("ID"))
+	}
+	if err := ds.Filter("Whole = 7"); err != nil || ds.RecordCount() != 1 {
+		t.Errorf("Filter by calculated field: %v, count %d", err, ds.RecordCount())
+	}
+	ds.Filter("")
+	ds.Edit()
+	if err := ds.SetValue("Double", "1"); err == nil {
+		t.Error("SetValue on calculated field expected error")
+	}
+	ds.SetValue("Amount", "1.5")
+	if ds.Value("Double") != "3" {
+		t.Errorf("Double while editing = %v", ds.Value("Double"))
+	}
+	ds.Cancel()
+
+	//查找字段
+	lines := newLines()
+	if err := lines.AddLookupField("Customer", "OrderID", ds, "ID", "Name"); err != nil {
+		t.Fatal(err)
+	}
+	var got []interface{}
+	for lines.First(); lines.Next(); {
+		got = append(got, lines.Value("Customer"))
+	}
+	if !reflect.DeepEqual(got, []interface{}{"alice", "bob", "alice", nil}) {
+		t.Errorf("lookup = %v", got)
+	}
+	if err := lines.RemoveField("Customer"); err != nil || lines.FieldDef("Customer") != nil {
+		t.Errorf("RemoveField: %v", err)
+	}
+	if err := lines.RemoveField("Name"); err == nil {
+		t.Error("RemoveField(data field) expected error")
+	}
+}
+
+func TestBookmark(t *testing.T) {
+	ds := newOrders()
+	var scrolls []string
+	ds.BeforeScroll = func(ds *DataSet) { scrolls = append(scrolls, "before") }
+	ds.AfterScroll = func(ds *DataSet) { scrolls = append(scrolls, "after") }
+	ds.Row(1)
+	if !reflect.DeepEqual(scrolls, []string{"before", "after"}) {
+		t.Errorf("scroll events = %v", scrolls)
+	}
+	b := ds.GetBookmark()
+
+	//排序、删除其他记录后仍可回到书签
+	ds.Sort("ID DESC")
+	ds.Locate("ID", 3)
+	ds.Delete()
+	ds.Last()
+	if !ds.GotoBookmark(b) || ds.ValueAsInteger("ID") != 2 {
+		t.Errorf("GotoBookmark after sort/delete: ID %v", ds.Value("ID"))
+	}
+	//被过滤时无效，记录删除后不再有效
+	ds.Filter("ID = 1")
+	if ds.GotoBookmark(b) || !ds.BookmarkValid(b) {
+		t.Error("GotoBookmark on filtered record")
+	}
+	ds.Filter("")
+	ds.GotoBookmark(b)
+	ds.Delete()
+	if ds.BookmarkValid(b) || ds.GotoBookmark(b) {
+		t.Error("bookmark of deleted record still valid")
+	}
+	if ds.BookmarkValid(Bookmark{}) {
+		t.Error("zero Bookmark valid")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ds := newOrders()
+	ds.AddFormulaField("Double", FieldDecimal, "Amount * 2")
+	ds.Filter("ID < 3")
+	snap := ds.Snapshot()
+
+	//快照只含可见的记录，计算字段作为数据字段保存，之后不随原数据集改变
+	ds.Row(0)
+	ds.Edit()
+	ds.SetValue("Amount", "1")
+	ds.Post()
+	if snap.RecordCount() != 2 || snap.FieldDef("Double").Kind != FieldData || snap.Records[0]["Double"] != "21" || snap.Records[0]["Amount"] != "10.50" {
+		t.Errorf("snapshot = %v", snap.Records)
+	}
+
+	//快照的Clone各自有记录指针，可并发浏览
+	var wg sync.WaitGroup
+	sums := make([]int, 4)
+	for i := range sums {
+		wg.Add(1)
+		go func(i int, c *DataSet) {
+			defer wg.Done()
+			c.Sort("ID DESC")
+			for c.Next() {
+				sums[i] = sums[i]*10 + c.ValueAsInteger("ID")
+			}
+		}(i, snap.Clone())
+	}
+	wg.Wait()
+	for i, sum := range sums {
+		if sum != 21 {
+			t.Errorf("clone %d: ids %d", i, sum)
+		}
+	}
+	if snap.RecIndex != -1 {
+		t.Errorf("snapshot RecIndex moved to %d", snap.RecIndex)
+	}
+
+	//可修改的数据集的Clone：看到字段值的修改，看不到增删的记录
+	c := ds.Clone()
+	ds.Append()
+	ds.SetValue("ID", int64(9))
+	ds.Post()
+	c.Filter("")
+	c.Row(0)
+	if c.RecordCount() != 3 || c.Value("Amount") != "1" || c.Edit() != ErrReadOnly {
+		t.Errorf("clone: count %d, Amount %v", c.RecordCount(), c.Value("Amount"))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := newOrders()
+	cur := newOrders()
+	cur.FieldDefs[2] = &FieldDef{Name: "Amount", Type: FieldFloat, Nullable: true}
+	cur.FieldDefs = append(cur.FieldDefs, &FieldDef{Name: "Note"})
+	cur.Records[0]["Amount"] = 10.5 //数值相同
+	cur.Records[1]["Name"] = "robert"
+	cur.Records = cur.Records[:2]
+	cur.Records = append(cur.Records, map[string]interface{}{"ID": int64(4), "Name": "dan"})
+	cur.Refresh()
+
+	d, err := Diff(old, cur, "ID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.AddedFields, []string{"Note"}) || d.RemovedFields != nil || !reflect.DeepEqual(d.ChangedFields, []string{"Amount"}) {
+		t.Errorf("fields: added %v, removed %v, changed %v", d.AddedFields, d.RemovedFields, d.ChangedFields)
+	}
+	if len(d.Added) != 1 || d.Added[0].Key[0] != int64(4) || len(d.Removed) != 1 || d.Removed[0].Key[0] != int64(3) {
+		t.Errorf("added %v, removed %v", d.Added, d.Removed)
+	}
+	want := []FieldChange{{"Name", "bob", "robert"}}
+	if len(d.Changed) != 1 || !reflect.DeepEqual(d.Changed[0].Fields, want) {
+		t.Errorf("changed = %+v", d.Changed)
+	}
+	if d.Empty() {
+		t.Error("Empty() = true")
+	}
+	if d, _ := Diff(old, newOrders(), "ID"); !d.Empty() {
+		t.Errorf("Diff of equal datasets = %+v", d)
+	}
+
+	//主键为空值与空字符串的记录不同
+	a := NewDataSet([]*FieldDef{{Name: "K", Nullable: true}, {Name: "V"}}, []map[string]interface{}{{"K": nil, "V": "1"}, {"K": "", "V": "2"}})
+	b := NewDataSet([]*FieldDef{{Name: "K", Nullable: true}, {Name: "V"}}, []map[string]interface{}{{"K": "", "V": "2"}})
+	if d, err := Diff(a, b, "K"); err != nil || len(d.Removed) != 1 || d.Removed[0].Key[0] != nil || len(d.Changed) != 0 {
+		t.Errorf("NULL/empty keys: %v %+v", err, d)
+	}
+	dup := NewDataSet(nil, []map[string]interface{}{{"K": "x"}, {"K": "x"}})
+	if _, err := Diff(dup, a, "K"); err == nil {
+		t.Error("Diff with duplicate key expected error")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	source := NewDataSet([]*FieldDef{{Name: "ID"}, {Name: "Name"}, {Name: "Amount"}, {Name: "Extra"}}, []map[string]interface{}{
+		{"ID": "1", "Name": "alice", "Amount": "11", "Extra": "x"},
+		{"ID": "3", "Name": "carol", "Amount": "3"},
+		{"ID": "4", "Name": "dan", "Amount": "4"},
+	})
+	cases := []struct {
+		policy MergePolicy
+		res    MergeResult
+		names  []interface{}
+		amount []interface{}
+	}{
+		{MergeOverwrite, MergeResult{Inserted: 1, Updated: 2}, []interface{}{"alice", "bob", "carol", "dan"}, []interface{}{"11", "7", "3", "4"}},
+		{MergeKeepTarget, MergeResult{Inserted: 1}, []interface{}{"alice", "bob", nil, "dan"}, []interface{}{"10.50", "7", nil, "4"}},
+		{MergeFillNull, MergeResult{Inserted: 1, Updated: 1}, []interface{}{"alice", "bob", "carol", "dan"}, []interface{}{"10.50", "7", "3", "4"}},
+		{MergeMirror, MergeResult{Inserted: 1, Updated: 2, Deleted: 1}, []interface{}{"alice", "carol", "dan"}, []interface{}{"11", "3", "4"}},
+	}
+	for _, c := range cases {
+		target := newOrders()
+		res, err := Merge(target, source, "ID", c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names, amount []interface{}
+		for _, rec := range target.Records {
+			names = append(names, rec["Name"])
+			amount = append(amount, rec["Amount"])
+		}
+		if res != c.res || !reflect.DeepEqual(names, c.names) || !reflect.DeepEqual(amount, c.amount) {
+			t.Errorf("policy %d: %+v, names %v, amount %v", c.policy, res, names, amount)
+		}
+		//值按target的字段类型转换，修改记入待保存的修改
+		if id := target.Records[len(target.Records)-1]["ID"]; id != int64(4) {
+			t.Errorf("policy %d: inserted ID %#v", c.policy, id)
+		}
+		if target.ChangeCount() != res.Inserted+res.Updated+res.Deleted {
+			t.Errorf("policy %d: %d changes", c.policy, target.ChangeCount())
+		}
+	}
+
+	bad := NewDataSet(nil, []map[string]interface{}{{"ID": "x", "Name": "y"}})
+	target := newOrders()
+	if _, err := Merge(target, bad, "ID", MergeOverwrite); err == nil || target.ChangeCount() != 0 {
+		t.Errorf("Merge with invalid value: %v, %d changes", err, target.ChangeCount())
+	}
+	if _, err := Merge(target.Snapshot(), source, "ID", MergeOverwrite); err != ErrReadOnly {
+		t.Errorf("Merge into snapshot = %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"fmt"
+)
+
+// 字段值的变化
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// 一条记录的差异
+type RowDiff struct {
+	Status UpdateStatus           //StatusInserted(新增)、StatusDeleted(删除)或StatusModified(修改)
+	Key    []interface{}          //主键字段的值
+	Old    map[string]interface{} //旧记录（新增的记录为nil）
+	New    map[string]interface{} //新记录（删除的记录为nil）
+	Fields []FieldChange          //修改的字段（只比较两边都有的字段）
+}
+
+// 两个数据集的差异
+type DiffResult struct {
+	AddedFields   []string //新增的字段
+	RemovedFields []string //删除的字段
+	ChangedFields []string //类型改变的字段
+	Added         []RowDiff
+	Removed       []RowDiff
+	Changed       []RowDiff
+}
+
+// 是否没有差异
+func (d *DiffResult) Empty() bool {
+	return len(d.AddedFields) == 0 && len(d.RemovedFields) == 0 && len(d.ChangedFields) == 0 &&
+		len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// 比较两个数据集（可见的记录）：按keyFields（多个字段用";"分隔，与Locate相同按字符串比较）对应记录，
+// 列出新增、删除及修改的记录（修改的记录列出每个字段的新旧值），以及字段的增删和类型改变。
+// 数值、日期按字段类型比较（1.10与1.1相同），主键重复时返回错误。
+func Diff(old, new *DataSet, keyFields string) (*DiffResult, error) {
+	keys := splitKeys(keyFields)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("dataset: no key fields")
+	}
+	for _, key := range keys {
+		if !old.hasField(key) || !new.hasField(key) {
+			return nil, fmt.Errorf("dataset: key field %s not found", key)
+		}
+	}
+	old.checkBrowseMode()
+	new.checkBrowseMode()
+
+	res := &DiffResult{}
+	oldDefs := map[string]*FieldDef{}
+	for _, def := range old.fieldDefs() {
+		oldDefs[def.Name] = def
+	}
+	newDefs := map[string]*FieldDef{}
+	var fields []*FieldDef //两边都有的字段（按新数据集的次序）
+	for _, def := range new.fieldDefs() {
+		newDefs[def.Name] = def
+		od := oldDefs[def.Name]
+		if od == nil {
+			res.AddedFields = append(res.AddedFields, def.Name)
+			continue
+		}
+		if od.Type != def.Type {
+			res.ChangedFields = append(res.ChangedFields, def.Name)
+		}
+		fields = append(fields, def)
+	}
+	for _, def := range old.fieldDefs() {
+		if newDefs[def.Name] == nil {
+			res.RemovedFields = append(res.RemovedFields, def.Name)
+		}
+	}
+
+	oldRows, err := old.keyMap(keys)
+	if err != nil {
+		return nil, err
+	}
+	newRows, err := new.keyMap(keys)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < new.recordCount; i++ {
+		rec := new.fullRecord(new.record(i))
+		key, values := new.recordKey(rec, keys)
+		orec, ok := oldRows[key]
+		if !ok {
+			res.Added = append(res.Added, RowDiff{Status: StatusInserted, Key: values, New: rec})
+			continue
+		}
+		var changes []FieldChange
+		for _, def := range fields {
+			if !sameValue(orec[def.Name], rec[def.Name], def.Type) {
+				changes = append(changes, FieldChange{Field: def.Name, Old: orec[def.Name], New: rec[def.Name]})
+			}
+		}
+		if len(changes) > 0 {
+			res.Changed = append(res.Changed, RowDiff{Status: StatusModified, Key: values, Old: orec, New: rec, Fields: changes})
+		}
+	}
+	for i := 0; i < old.recordCount; i++ {
+		rec := old.fullRecord(old.record(i))
+		key, values := old.recordKey(rec, keys)
+		if _, ok := newRows[key]; !ok {
+			res.Removed = append(res.Removed, RowDiff{Status: StatusDeleted, Key: values, Old: rec})
+		}
+	}
+	return res, nil
+}
+
+// 可见记录的主键 -> 记录（含计算字段），主键重复时返回错误
+func (ds *DataSet) keyMap(keys []string) (map[string]map[string]interface{}, error) {
+	m := make(map[string]map[string]interface{}, ds.recordCount)
+	for i := 0; i < ds.recordCount; i++ {
+		rec := ds.fullRecord(ds.record(i))
+		key, values := ds.recordKey(rec, keys)
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("dataset: duplicate key %v", values)
+		}
+		m[key] = rec
+	}
+	return m, nil
+}
+
+// 记录的主键（见groupKey）及主键字段的值
+func (ds *DataSet) recordKey(rec map[string]interface{}, keys []string) (string, []interface{}) {
+	values := make([]interface{}, len(keys))
+	for k, key := range keys {
+		values[k] = ds.get(rec, key)
+	}
+	return groupKey(values), values
+}
+
+// 两个值是否相同（按字段类型比较）
+func sameValue(a, b interface{}, t FieldType) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return compareField(a, b, t) == 0
+}
+
+// 合并方式
+type MergePolicy int
+
+const (
+	MergeOverwrite  MergePolicy = iota //新增target中没有的记录，已有的记录用source的值覆盖
+	MergeKeepTarget                    //只新增target中没有的记录，已有的记录不变
+	MergeFillNull                      //新增target中没有的记录，已有的记录只填充为空值(NULL)的字段
+	MergeMirror                        //同MergeOverwrite，并删除source中没有的记录（target与source一致）
+)
+
+// 合并的结果
+type MergeResult struct {
+	Inserted int
+	Updated  int
+	Deleted  int
+}
+
+// 把source（可见的记录）合并到target：按keyFields（多个字段用";"分隔，与Locate相同按字符串比较）对应记录，
+// 按policy新增、修改或删除target的记录（target的全部记录，不受过滤影响）。只合并target中有的数据字段，值按target的字段类型转换。
+// 修改记入target的待保存修改，之后可用ApplyUpdates写入数据库。
+func Merge(target, source *DataSet, keyFields string, policy MergePolicy) (MergeResult, error) {
+	var res MergeResult
+	if target.readOnly {
+		return res, ErrReadOnly
+	}
+	keys := splitKeys(keyFields)
+	if len(keys) == 0 {
+		return res, fmt.Errorf("dataset: no key fields")
+	}
+	for _, key := range keys {
+		if !target.hasField(key) || !source.hasField(key) {
+			return res, fmt.Errorf("dataset: key field %s not found", key)
+		}
+	}
+	target.checkBrowseMode()
+	source.checkBrowseMode()
+
+	//target的数据字段（source中也有的）
+	var fields []*FieldDef
+	for _, def := range target.fieldDefs() {
+		if def.Kind == FieldData && source.hasField(def.Name) {
+			fields = append(fields, def)
+		}
+	}
+
+	//target全部记录的主键 -> Records中的下标
+	rows := make(map[string]int, len(target.Records))
+	for r, rec := range target.Records {
+		key, values := target.recordKey(rec, keys)
+		if _, ok := rows[key]; ok {
+			return res, fmt.Errorf("dataset: duplicate key %v in target", values)
+		}
+		rows[key] = r
+	}
+
+	//先转换source的全部记录，出错时不修改target
+	type sourceRow struct {
+		key string
+		rec map[string]interface{}
+	}
+	srcRows := make([]sourceRow, 0, source.recordCount)
+	seen := make(map[string]bool, source.recordCount)
+	for i := 0; i < source.recordCount; i++ {
+		src := source.record(i)
+		rec := make(map[string]interface{}, len(fields))
+		for _, def := range fields {
+			v, err := convertValue(source.get(src, def.Name), def.Type)
+			if err != nil {
+				return res, fmt.Errorf("dataset: source record %d field %s: %w", i, def.Name, err)
+			}
+			rec[def.Name] = v
+		}
+		key, values := target.recordKey(rec, keys)
+		if seen[key] {
+			return res, fmt.Errorf("dataset: duplicate key %v in source", values)
+		}
+		seen[key] = true
+		srcRows = append(srcRows, sourceRow{key, rec})
+	}
+
+	b := target.GetBookmark()
+	for _, src := range srcRows {
+		r, ok := rows[src.key]
+		if !ok {
+			rec := make(map[string]interface{}, len(target.FieldDefs))
+			for _, name := range target.fieldNames() {
+				rec[name] = src.rec[name]
+			}
+			target.insertRow(len(target.Records), target.recordCount, rec, &rowStatus{status: StatusInserted})
+			res.Inserted++
+			continue
+		}
+		if policy == MergeKeepTarget {
+			continue
+		}
+		rec := target.Records[r]
+		modified := false
+		for _, def := range fields {
+			v := src.rec[def.Name]
+			if sameValue(rec[def.Name], v, def.Type) || (policy == MergeFillNull && rec[def.Name] != nil) {
+				continue
+			}
+			if !modified && target.rowStatus(r) == nil {
+				target.setRowStatus(r, &rowStatus{status: StatusModified, original: copyRecord(rec)})
+			}
+			rec[def.Name] = v
+			modified = true
+		}
+		if modified {
+			res.Updated++
+		}
+	}
+	if policy == MergeMirror {
+		for r := len(target.Records) - 1; r >= 0; r-- {
+			key, _ := target.recordKey(target.Records[r], keys)
+			if !seen[key] {
+				target.deleteRow(r)
+				res.Deleted++
+			}
+		}
+	}
+
+	target.indexDirty = true
+	if target.viewActive() {
+		target.buildView()
+	}
+	target.RecIndex = -1
+	if r := target.bookmarkRow(b); r >= 0 {
+		target.RecIndex = target.position(r)
+	}
+	if target.RecIndex < 0 && target.recordCount > 0 {
+		target.RecIndex = 0
+	}
+	target.scrolled()
+	target.changed("")
+	return res, nil
+}
@@ -0,0 +1,371 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// 数据集状态
+type DataSetState int
+
+const (
+	StateBrowse DataSetState = iota //浏览
+	StateEdit                       //修改当前记录（Edit）
+	StateInsert                     //新增记录（Append/Insert）
+)
+
+// 记录的修改状态
+type UpdateStatus int
+
+const (
+	StatusUnmodified UpdateStatus = iota //未修改
+	StatusModified                       //已修改
+	StatusInserted                       //新增
+	StatusDeleted                        //已删除
+)
+
+func (s UpdateStatus) String() string {
+	switch s {
+	case StatusUnmodified:
+		return "unmodified"
+	case StatusModified:
+		return "modified"
+	case StatusInserted:
+		return "inserted"
+	case StatusDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("UpdateStatus(%d)", int(s))
+}
+
+var (
+	ErrNotEditing = errors.New("dataset: not in edit or insert state")
+	ErrNoRecord   = errors.New("dataset: no current record")
+	ErrReadOnly   = errors.New("dataset: read-only dataset")
+)
+
+// 记录的修改信息（与Records按下标对应，nil表示未修改）
+type rowStatus struct {
+	status   UpdateStatus
+	original map[string]interface{} //修改前的原值（新增的记录为nil）
+}
+
+// 一条待保存的修改
+type Change struct {
+	Status   UpdateStatus
+	Index    int                    //在Records中的下标（已删除的记录为-1）
+	Record   map[string]interface{} //当前值（已删除的记录为nil）
+	Original map[string]interface{} //原值（新增的记录为nil）
+}
+
+// 数据集状态
+func (ds *DataSet) State() DataSetState {
+	return ds.state
+}
+
+// 修改当前记录，之后用SetValue赋值，Post保存或Cancel放弃
+func (ds *DataSet) Edit() error {
+	if ds.state != StateBrowse {
+		return nil
+	}
+	if ds.readOnly {
+		return ErrReadOnly
+	}
+	i := ds.recno()
+	if i < 0 {
+		return ErrNoRecord
+	}
+	ds.editIndex = ds.row(i)
+	ds.buffer = copyRecord(ds.Records[ds.editIndex])
+	ds.state = StateEdit
+	return nil
+}
+
+// 在末尾新增一条记录
+func (ds *DataSet) Append() error {
+	if ds.readOnly {
+		return ErrReadOnly
+	}
+	ds.checkBrowseMode()
+	ds.beginInsert(len(ds.Records), ds.recordCount)
+	return nil
+}
+
+// 在当前记录之前插入一条记录
+func (ds *DataSet) Insert() error {
+	if ds.readOnly {
+		return ErrReadOnly
+	}
+	ds.checkBrowseMode()
+	p := ds.recno()
+	if p < 0 {
+		ds.beginInsert(len(ds.Records), 0)
+		return nil
+	}
+	ds.beginInsert(ds.row(p), p)
+	return nil
+}
+
+// 开始新增记录，r为在Records中的插入位置，p为在浏览次序中的插入位置
+func (ds *DataSet) beginInsert(r, p int) {
+	ds.buffer = make(map[string]interface{}, len(ds.FieldDefs))
+	for _, name := range ds.fieldNames() {
+		ds.buffer[name] = nil
+	}
+	//明细数据集的关联字段取主数据集当前记录的值
+	if l := ds.link; l != nil && l.active {
+		values, _ := l.masterValues()
+		for k, name := range l.detailFields {
+			ds.buffer[name] = values[k]
+		}
+	}
+	ds.editIndex = r
+	ds.insertPos = p
+	ds.state = StateInsert
+	ds.scrolled()
+	ds.changed("")
+}
+
+// 为编辑中的记录的字段赋值
+func (ds *DataSet) SetValue(name string, value interface{}) error {
+	if ds.state == StateBrowse {
+		return ErrNotEditing
+	}
+	if !ds.hasField(name) {
+		return fmt.Errorf("dataset: field %s not found", name)
+	}
+	if ds.isVirtual(name) {
+		return fmt.Errorf("dataset: field %s is read-only", name)
+	}
+	ds.buffer[name] = value
+	ds.changed(name)
+	return nil
+}
+
+// 保存编辑中的记录
+func (ds *DataSet) Post() error {
+	p := ds.insertPos
+	if ds.state == StateEdit {
+		p = ds.position(ds.editIndex)
+	}
+	switch ds.state {
+	case StateEdit:
+		i := ds.editIndex
+		if !sameValues(ds.buffer, ds.Records[i]) {
+			st := ds.rowStatus(i)
+			if st == nil {
+				st = &rowStatus{status: StatusModified, original: copyRecord(ds.Records[i])}
+				ds.setRowStatus(i, st)
+			}
+			for name, value := range ds.buffer {
+				ds.Records[i][name] = value
+			}
+			//改回原值时不再作为修改
+			if st.status == StatusModified && sameValues(ds.Records[i], st.original) {
+				ds.setRowStatus(i, nil)
+			}
+			ds.indexDirty = true
+		}
+	case StateInsert:
+		ds.insertRow(ds.editIndex, ds.insertPos, ds.buffer, &rowStatus{status: StatusInserted})
+	default:
+		return ErrNotEditing
+	}
+	ds.buffer = nil
+	ds.state = StateBrowse
+
+	//已排序或过滤时重新排序、过滤，记录指针指向保存的记录（不再可见时停在原位置）
+	if ds.viewActive() {
+		ds.buildView()
+	}
+	if q := ds.position(ds.editIndex); q >= 0 {
+		p = q
+	}
+	ds.RecIndex = p
+	ds.scrolled()
+	ds.changed("")
+	return nil
+}
+
+// 放弃编辑中的记录
+func (ds *DataSet) Cancel() {
+	if ds.state == StateBrowse {
+		return
+	}
+	ds.buffer = nil
+	ds.state = StateBrowse
+	ds.scrolled()
+	ds.changed("")
+}
+
+// 删除当前记录，记录指针停在下一条记录上；新增中只取消新增的记录
+func (ds *DataSet) Delete() error {
+	if ds.readOnly {
+		return ErrReadOnly
+	}
+	if ds.state == StateInsert {
+		ds.Cancel()
+		return nil
+	}
+	ds.Cancel()
+	p := ds.recno()
+	if p < 0 {
+		return ErrNoRecord
+	}
+	ds.deleteRow(ds.row(p))
+	ds.RecIndex = p
+	ds.scrolled()
+	ds.changed("")
+	return nil
+}
+
+// 删除Records中的第r条记录，并记录为待保存的删除（新增的记录直接删除）
+func (ds *DataSet) deleteRow(r int) {
+	st := ds.rowStatus(r)
+	switch {
+	case st == nil:
+		ds.deleted = append(ds.deleted, &rowStatus{status: StatusDeleted, original: ds.Records[r]})
+	case st.status == StatusModified:
+		ds.deleted = append(ds.deleted, &rowStatus{status: StatusDeleted, original: st.original})
+	}
+	ds.removeRow(r)
+}
+
+// 当前记录的修改状态
+func (ds *DataSet) UpdateStatus() UpdateStatus {
+	if ds.state == StateInsert {
+		return StatusInserted
+	}
+	if i := ds.recno(); i >= 0 {
+		if st := ds.rowStatus(ds.row(i)); st != nil {
+			return st.status
+		}
+	}
+	return StatusUnmodified
+}
+
+// 当前记录字段修改前的值（新增的记录返回nil）
+func (ds *DataSet) OldValue(name string) interface{} {
+	if ds.state == StateInsert {
+		return nil
+	}
+	i := ds.recno()
+	if i < 0 {
+		return nil
+	}
+	r := ds.row(i)
+	if st := ds.rowStatus(r); st != nil {
+		if st.status == StatusInserted {
+			return nil
+		}
+		return st.original[name]
+	}
+	return ds.Records[r][name]
+}
+
+// 待保存的修改条数
+func (ds *DataSet) ChangeCount() int {
+	n := len(ds.deleted)
+	for _, st := range ds.status {
+		if st != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// 待保存的修改（先列出已删除的记录，再按记录次序列出修改及新增的记录）
+func (ds *DataSet) Changes() []Change {
+	changes := make([]Change, 0, ds.ChangeCount())
+	for _, st := range ds.deleted {
+		changes = append(changes, Change{Status: StatusDeleted, Index: -1, Original: st.original})
+	}
+	for i, st := range ds.status {
+		if st != nil && i < len(ds.Records) {
+			changes = append(changes, Change{Status: st.status, Index: i, Record: ds.Records[i], Original: st.original})
+		}
+	}
+	return changes
+}
+
+// 修改已保存到数据库后，清除修改记录
+func (ds *DataSet) CommitUpdates() {
+	ds.status = nil
+	ds.deleted = nil
+}
+
+// 调用OnChange
+func (ds *DataSet) changed(field string) {
+	if ds.OnChange != nil {
+		ds.OnChange(ds, field)
+	}
+}
+
+// 移动记录指针前自动保存编辑中的记录
+func (ds *DataSet) checkBrowseMode() {
+	if ds.state != StateBrowse {
+		if err := ds.Post(); err != nil {
+			ds.Error = err
+		}
+	}
+}
+
+func (ds *DataSet) rowStatus(i int) *rowStatus {
+	if i < 0 || i >= len(ds.status) {
+		return nil
+	}
+	return ds.status[i]
+}
+
+func (ds *DataSet) setRowStatus(i int, st *rowStatus) {
+	ds.alignStatus()
+	ds.status[i] = st
+}
+
+// 修改状态与Records等长
+func (ds *DataSet) alignStatus() {
+	for len(ds.status) < len(ds.Records) {
+		ds.status = append(ds.status, nil)
+	}
+}
+
+// 数据字段名（无字段信息时取首条记录的字段），不含计算字段、查找字段
+func (ds *DataSet) fieldNames() []string {
+	names := make([]string, 0, len(ds.FieldDefs))
+	for _, def := range ds.FieldDefs {
+		if def.Kind == FieldData {
+			names = append(names, def.Name)
+		}
+	}
+	if len(names) == 0 && len(ds.Records) > 0 {
+		for name := range ds.Records[0] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	return names
+}
+
+func (ds *DataSet) hasField(name string) bool {
+	return len(ds.FieldDefs) == 0 || ds.FieldDef(name) != nil
+}
+
+// buffer中的字段值是否都与record相同
+func sameValues(buffer, record map[string]interface{}) bool {
+	for name, value := range buffer {
+		if !reflect.DeepEqual(value, record[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func copyRecord(record map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(record))
+	for k, v := range record {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,456 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 导出为CSV（第1行为字段名）：空值(NULL)为空字段，空字符串为""；日期时间为RFC3339格式，二进制为base64。
+// 按浏览次序导出可见的记录（排序、过滤后的结果）。CSV中不保存字段类型，导入时传入ds.FieldDefs才能还原类型。
+func (ds *DataSet) SaveToCSV(w io.Writer) error {
+	ds.checkBrowseMode()
+	defs := ds.fieldDefs()
+	bw := bufio.NewWriter(w)
+	for i, def := range defs {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		writeCSVField(bw, def.Name)
+	}
+	bw.WriteByte('\n')
+	for p := 0; p < ds.recordCount; p++ {
+		rec := ds.record(p)
+		for i, def := range defs {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			if v := ds.get(rec, def.Name); v != nil {
+				writeCSVField(bw, formatValue(v, def.Type))
+			}
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeCSVField(w *bufio.Writer, s string) {
+	if s != "" && !strings.ContainsAny(s, ",\"\r\n") && strings.TrimSpace(s) == s {
+		w.WriteString(s)
+		return
+	}
+	w.WriteByte('"')
+	w.WriteString(strings.ReplaceAll(s, `"`, `""`))
+	w.WriteByte('"')
+}
+
+// 从CSV导入（第1行为字段名），fields为字段定义（按字段名对应），
+// 未定义的字段按数据推断类型（整数、浮点数、布尔(仅"true"/"false")、日期时间，否则为字符串；有前导0的值如"007"保留为字符串）。
+// 没有引号的空字段为空值(NULL)，""为空字符串。
+func LoadFromCSV(r io.Reader, fields ...*FieldDef) (*DataSet, error) {
+	cr := &csvReader{r: bufio.NewReader(r), line: 1}
+	header, err := cr.read()
+	if err == io.EOF {
+		return NewDataSet(nil, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]csvCell
+	for {
+		row, err := cr.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) == 1 && len(header) > 1 && !row[0].quoted && row[0].s == "" {
+			continue //空行
+		}
+		if len(row) > len(header) {
+			return nil, fmt.Errorf("dataset: csv line %d: %d fields, header has %d", cr.line-1, len(row), len(header))
+		}
+		rows = append(rows, row)
+	}
+
+	defs := make([]*FieldDef, len(header))
+	for i, cell := range header {
+		for _, f := range fields {
+			if f.Name == cell.s {
+				defs[i] = f
+			}
+		}
+		if defs[i] == nil {
+			values := make([]interface{}, 0, len(rows))
+			for _, row := range rows {
+				if i < len(row) && (row[i].quoted || row[i].s != "") {
+					values = append(values, row[i].s)
+				}
+			}
+			defs[i] = &FieldDef{Name: cell.s, Type: inferType(values), Nullable: true}
+		}
+	}
+
+	records := make([]map[string]interface{}, len(rows))
+	for n, row := range rows {
+		rec := make(map[string]interface{}, len(defs))
+		for i, def := range defs {
+			rec[def.Name] = nil
+			if i >= len(row) || (!row[i].quoted && row[i].s == "") {
+				continue
+			}
+			if row[i].s == "" && def.Type != FieldString {
+				continue
+			}
+			v, err := convertValue(row[i].s, def.Type)
+			if err != nil {
+				return nil, fmt.Errorf("dataset: csv record %d field %s: %w", n+1, def.Name, err)
+			}
+			rec[def.Name] = v
+		}
+		records[n] = rec
+	}
+	return NewDataSet(defs, records), nil
+}
+
+// 按文本推断字段类型，有前导0的值（如邮编"007"）不推断为数值，以免丢失前导0
+func inferType(values []interface{}) FieldType {
+	if len(values) == 0 {
+		return FieldString
+	}
+	zeros := false
+	for _, v := range values {
+		if s, ok := v.(string); ok && leadingZero(s) {
+			zeros = true
+			break
+		}
+	}
+	for _, t := range []FieldType{FieldInteger, FieldFloat, FieldBoolean, FieldDateTime} {
+		if zeros && t.IsNumber() {
+			continue
+		}
+		ok := true
+		for _, v := range values {
+			if _, err := convertValue(v, t); err != nil || (t == FieldBoolean && !boolText(v)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return t
+		}
+	}
+	return FieldString
+}
+
+// 是否为"true"或"false"（不区分大小写）；"T"、"1"等不推断为布尔，以免单字母或0/1的列被当作布尔
+func boolText(v interface{}) bool {
+	s, ok := v.(string)
+	s = strings.TrimSpace(s)
+	return ok && (strings.EqualFold(s, "true") || strings.EqualFold(s, "false"))
+}
+
+// 是否有前导0（"0"、"0.5"、"-0.5"除外）
+func leadingZero(s string) bool {
+	s = strings.TrimLeft(strings.TrimSpace(s), "+-")
+	return len(s) > 1 && s[0] == '0' && s[1] >= '0' && s[1] <= '9'
+}
+
+type csvCell struct {
+	s      string
+	quoted bool
+}
+
+// 读取CSV记录，保留字段是否带引号（用于区分空值与空字符串）
+type csvReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (cr *csvReader) read() ([]csvCell, error) {
+	var (
+		row  []csvCell
+		cell strings.Builder
+		c    csvCell
+	)
+	start := true
+	inQuotes := false
+	for {
+		ch, _, err := cr.r.ReadRune()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, fmt.Errorf("dataset: csv line %d: unterminated quoted field", cr.line)
+			}
+			if len(row) == 0 && start && cell.Len() == 0 && !c.quoted {
+				return nil, io.EOF
+			}
+			c.s = cell.String()
+			return append(row, c), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ch == '\uFEFF' && cr.line == 1 && len(row) == 0 && start {
+			continue
+		}
+		switch {
+		case inQuotes:
+			if ch == '"' {
+				if next, _ := cr.r.Peek(1); len(next) == 1 && next[0] == '"' {
+					cr.r.ReadByte()
+					cell.WriteByte('"')
+				} else {
+					inQuotes = false
+				}
+			} else {
+				if ch == '\n' {
+					cr.line++
+				}
+				cell.WriteRune(ch)
+			}
+		case ch == '"' && start:
+			inQuotes = true
+			c.quoted = true
+			start = false
+		case ch == ',':
+			c.s = cell.String()
+			row = append(row, c)
+			cell.Reset()
+			c = csvCell{}
+			start = true
+		case ch == '\r':
+		case ch == '\n':
+			cr.line++
+			c.s = cell.String()
+			return append(row, c), nil
+		default:
+			cell.WriteRune(ch)
+			start = false
+		}
+	}
+}
+
+// 导出为JSON：{"fields":[字段定义...],"records":[{字段名:值,...},...]}，
+// 空值为null，定点数为JSON数值（保留原精度），日期时间为RFC3339格式，二进制为base64。
+// 按浏览次序导出可见的记录。
+func (ds *DataSet) SaveToJSON(w io.Writer) error {
+	ds.checkBrowseMode()
+	defs := ds.fieldDefs()
+	bw := bufio.NewWriter(w)
+	b, err := json.Marshal(defs)
+	if err != nil {
+		return err
+	}
+	bw.WriteString(`{"fields":`)
+	bw.Write(b)
+	bw.WriteString(`,"records":[`)
+	names := make([][]byte, len(defs))
+	for i, def := range defs {
+		names[i], _ = json.Marshal(def.Name)
+	}
+	for p := 0; p < ds.recordCount; p++ {
+		if p > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString("\n{")
+		rec := ds.record(p)
+		for i, def := range defs {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			bw.Write(names[i])
+			bw.WriteByte(':')
+			b, err := jsonValue(ds.get(rec, def.Name), def.Type)
+			if err != nil {
+				return fmt.Errorf("dataset: record %d field %s: %w", p, def.Name, err)
+			}
+			bw.Write(b)
+		}
+		if err := bw.WriteByte('}'); err != nil {
+			return err
+		}
+	}
+	bw.WriteString("\n]}\n")
+	return bw.Flush()
+}
+
+func jsonValue(v interface{}, t FieldType) ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+	cv, err := convertValue(v, t)
+	if err != nil {
+		return nil, err
+	}
+	switch x := cv.(type) {
+	case time.Time:
+		return json.Marshal(x.Format(time.RFC3339Nano))
+	case string:
+		if t == FieldDecimal {
+			return json.Marshal(json.Number(x))
+		}
+	}
+	return json.Marshal(cv)
+}
+
+// 从JSON导入：SaveToJSON的格式，或不含字段定义的记录数组[{...},...]（按数据推断类型）
+func LoadFromJSON(r io.Reader) (*DataSet, error) {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	var doc struct {
+		Fields  []*FieldDef              `json:"fields"`
+		Records []map[string]interface{} `json:"records"`
+	}
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			br.ReadByte()
+			continue
+		}
+		if b[0] == '[' {
+			err = dec.Decode(&doc.Records)
+		} else {
+			err = dec.Decode(&doc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	defs := doc.Fields
+	if defs == nil {
+		defs = inferJSONFields(doc.Records)
+	}
+	for n, rec := range doc.Records {
+		for _, def := range defs {
+			v, err := convertValue(rec[def.Name], def.Type)
+			if err != nil {
+				return nil, fmt.Errorf("dataset: json record %d field %s: %w", n, def.Name, err)
+			}
+			rec[def.Name] = v
+		}
+	}
+	return NewDataSet(defs, doc.Records), nil
+}
+
+// 按JSON值推断字段定义（字段按名称排序）
+func inferJSONFields(records []map[string]interface{}) []*FieldDef {
+	types := map[string]FieldType{}
+	known := map[string]bool{} //已有非空值
+	for _, rec := range records {
+		for name, v := range rec {
+			if _, ok := types[name]; !ok {
+				types[name] = FieldString
+			}
+			var t FieldType
+			switch x := v.(type) {
+			case nil:
+				continue
+			case json.Number:
+				t = FieldInteger
+				if _, err := x.Int64(); err != nil {
+					t = FieldFloat
+				}
+			case bool:
+				t = FieldBoolean
+			default:
+				t = FieldString
+			}
+			if old := types[name]; known[name] && old != t {
+				if old.IsNumber() && t.IsNumber() {
+					t = FieldFloat
+				} else {
+					t = FieldString
+				}
+			}
+			types[name] = t
+			known[name] = true
+		}
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	defs := make([]*FieldDef, len(names))
+	for i, name := range names {
+		defs[i] = &FieldDef{Name: name, Type: types[name], Nullable: true}
+	}
+	return defs
+}
+
+// 导出为Excel 2003 XML(SpreadsheetML)表格，可直接用Excel打开；第1行为字段名，空值为空单元格。
+// 按浏览次序导出可见的记录。
+func (ds *DataSet) SaveToSpreadsheetML(w io.Writer, sheetName string) error {
+	ds.checkBrowseMode()
+	if sheetName == "" {
+		sheetName = "Sheet1"
+	}
+	defs := ds.fieldDefs()
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<?mso-application progid="Excel.Sheet"?>` + "\n")
+	bw.WriteString(`<Workbook xmlns="urn:schemas-microsoft-com:office:spreadsheet" xmlns:ss="urn:schemas-microsoft-com:office:spreadsheet">` + "\n")
+	bw.WriteString(`<Styles><Style ss:ID="h"><Font ss:Bold="1"/></Style><Style ss:ID="d"><NumberFormat ss:Format="yyyy\-mm\-dd\ hh:mm:ss"/></Style></Styles>` + "\n")
+	bw.WriteString(`<Worksheet ss:Name="` + xmlEscape(sheetName) + `"><Table>` + "\n")
+	bw.WriteString("<Row>")
+	for _, def := range defs {
+		bw.WriteString(`<Cell ss:StyleID="h"><Data ss:Type="String">` + xmlEscape(def.Name) + `</Data></Cell>`)
+	}
+	bw.WriteString("</Row>\n")
+	for p := 0; p < ds.recordCount; p++ {
+		rec := ds.record(p)
+		bw.WriteString("<Row>")
+		for _, def := range defs {
+			v := ds.get(rec, def.Name)
+			if v == nil {
+				bw.WriteString("<Cell/>")
+				continue
+			}
+			typ, style, text := "String", "", formatValue(v, def.Type)
+			if cv, err := convertValue(v, def.Type); err == nil {
+				switch x := cv.(type) {
+				case int64, float64:
+					typ = "Number"
+				case string:
+					if def.Type == FieldDecimal {
+						typ = "Number"
+					}
+				case bool:
+					typ, text = "Boolean", "0"
+					if x {
+						text = "1"
+					}
+				case time.Time:
+					typ, style, text = "DateTime", ` ss:StyleID="d"`, x.Format("2006-01-02T15:04:05.000")
+				}
+			}
+			bw.WriteString(`<Cell` + style + `><Data ss:Type="` + typ + `">` + xmlEscape(text) + `</Data></Cell>`)
+		}
+		if _, err := bw.WriteString("</Row>\n"); err != nil {
+			return err
+		}
+	}
+	bw.WriteString("</Table></Worksheet>\n</Workbook>\n")
+	return bw.Flush()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
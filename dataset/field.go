@@ -0,0 +1,307 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"ninego/skit"
+)
+
+// 字段数据类型
+type FieldType int
+
+const (
+	FieldString   FieldType = iota //字符串(string)
+	FieldInteger                   //整数(int64)
+	FieldFloat                     //浮点数(float64)
+	FieldDecimal                   //定点数(以string保存，不损失精度)
+	FieldBoolean                   //布尔(bool)
+	FieldDateTime                  //日期时间(time.Time)
+	FieldBytes                     //二进制([]byte)
+)
+
+var fieldTypeNames = []string{"string", "integer", "float", "decimal", "boolean", "datetime", "bytes"}
+
+func (t FieldType) String() string {
+	if t >= 0 && int(t) < len(fieldTypeNames) {
+		return fieldTypeNames[t]
+	}
+	return fmt.Sprintf("FieldType(%d)", int(t))
+}
+
+func (t FieldType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *FieldType) UnmarshalText(b []byte) error {
+	for i, name := range fieldTypeNames {
+		if strings.EqualFold(name, string(b)) {
+			*t = FieldType(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("dataset: unknown field type %q", b)
+}
+
+// 数值类型（整数、浮点数、定点数）
+func (t FieldType) IsNumber() bool {
+	return t == FieldInteger || t == FieldFloat || t == FieldDecimal
+}
+
+// 字段定义（由sql.ColumnType生成，或由LoadFromCSV、LoadFromJSON等生成）
+type FieldDef struct {
+	Name     string    `json:"name"`
+	Type     FieldType `json:"type"`
+	DataType string    `json:"dataType,omitempty"` //数据库类型名，如VARCHAR、INT、DECIMAL
+	Nullable bool      `json:"nullable"`
+	Kind     FieldKind `json:"-"` //数据字段、计算字段或查找字段
+}
+
+// 由字段定义及记录创建数据集（records可为nil）
+func NewDataSet(fields []*FieldDef, records []map[string]interface{}) *DataSet {
+	if records == nil {
+		records = make([]map[string]interface{}, 0)
+	}
+	ds := &DataSet{FieldDefs: fields, Records: records, RecIndex: -1}
+	ds.recordCount = len(records)
+	return ds
+}
+
+// 由sql.ColumnType生成字段定义
+func fieldDefs(cols []*sql.ColumnType) []*FieldDef {
+	defs := make([]*FieldDef, len(cols))
+	for i, col := range cols {
+		nullable, ok := col.Nullable()
+		defs[i] = &FieldDef{
+			Name:     col.Name(),
+			Type:     columnType(col),
+			DataType: col.DatabaseTypeName(),
+			Nullable: nullable || !ok,
+		}
+	}
+	return defs
+}
+
+var (
+	typeTime     = reflect.TypeOf(time.Time{})
+	typeNullTime = reflect.TypeOf(sql.NullTime{})
+)
+
+// 按ScanType及数据库类型名确定字段类型
+func columnType(col *sql.ColumnType) FieldType {
+	name := strings.ToUpper(col.DatabaseTypeName())
+	//DECIMAL等类型的ScanType常为RawBytes/string/float64，按类型名判断
+	if strings.Contains(name, "DECIMAL") || strings.Contains(name, "NUMERIC") || strings.Contains(name, "MONEY") {
+		return FieldDecimal
+	}
+	if t := col.ScanType(); t != nil {
+		switch t {
+		case typeTime, typeNullTime:
+			return FieldDateTime
+		case reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(sql.NullInt32{}), reflect.TypeOf(sql.NullInt16{}), reflect.TypeOf(sql.NullByte{}):
+			return FieldInteger
+		case reflect.TypeOf(sql.NullFloat64{}):
+			return FieldFloat
+		case reflect.TypeOf(sql.NullBool{}):
+			return FieldBoolean
+		}
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return FieldInteger
+		case reflect.Float32, reflect.Float64:
+			return FieldFloat
+		case reflect.Bool:
+			return FieldBoolean
+		}
+	}
+	switch {
+	case strings.Contains(name, "INT"):
+		return FieldInteger
+	case strings.Contains(name, "FLOAT"), strings.Contains(name, "DOUBLE"), strings.Contains(name, "REAL"), strings.Contains(name, "NUMBER"):
+		return FieldFloat
+	case strings.Contains(name, "DATE"), strings.Contains(name, "TIME"):
+		return FieldDateTime
+	case strings.HasPrefix(name, "BOOL"), name == "BIT":
+		return FieldBoolean
+	case strings.Contains(name, "BLOB"), strings.Contains(name, "BINARY"), name == "BYTEA":
+		return FieldBytes
+	}
+	return FieldString
+}
+
+// 按值的类型确定字段类型
+func valueType(v interface{}) FieldType {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return FieldInteger
+	case float32, float64:
+		return FieldFloat
+	case time.Time:
+		return FieldDateTime
+	case bool:
+		return FieldBoolean
+	case []byte:
+		return FieldBytes
+	}
+	return FieldString
+}
+
+// 字段定义，不存在时返回nil
+func (ds *DataSet) FieldDef(name string) *FieldDef {
+	for _, def := range ds.FieldDefs {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// 字段类型（无字段定义时按首个非空值的类型确定）
+func (ds *DataSet) fieldType(name string) FieldType {
+	if def := ds.FieldDef(name); def != nil {
+		return def.Type
+	}
+	for _, rec := range ds.Records {
+		if v := rec[name]; v != nil {
+			return valueType(v)
+		}
+	}
+	return FieldString
+}
+
+// 字段定义（无字段定义时按首条记录生成）
+func (ds *DataSet) fieldDefs() []*FieldDef {
+	if len(ds.FieldDefs) > 0 || len(ds.Records) == 0 {
+		return ds.FieldDefs
+	}
+	names := ds.fieldNames()
+	defs := make([]*FieldDef, len(names))
+	for i, name := range names {
+		defs[i] = &FieldDef{Name: name, Type: ds.fieldType(name), Nullable: true}
+	}
+	return defs
+}
+
+// 日期时间的文本格式（导出时用第1种）
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("dataset: cannot parse %q as datetime", s)
+}
+
+// 将值转换为字段类型对应的Go类型（见FieldType），nil不转换
+func convertValue(v interface{}, t FieldType) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if n, ok := v.(json.Number); ok {
+		v = n.String()
+	}
+	switch t {
+	case FieldInteger:
+		switch x := v.(type) {
+		case int64:
+			return x, nil
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		}
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(rv.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			return int64(rv.Float()), nil
+		}
+	case FieldFloat:
+		if f, ok := toFloat(v); ok {
+			return f, nil
+		}
+	case FieldDecimal:
+		switch x := v.(type) {
+		case string:
+			if _, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err != nil {
+				return nil, fmt.Errorf("dataset: cannot parse %q as decimal", x)
+			}
+			return strings.TrimSpace(x), nil
+		case []byte:
+			return convertValue(string(x), t)
+		case decimal.Decimal:
+			return x.String(), nil
+		}
+		if _, ok := toFloat(v); ok {
+			return skit.String(v), nil
+		}
+	case FieldBoolean:
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(x))
+		}
+		if f, ok := toFloat(v); ok {
+			return f != 0, nil
+		}
+	case FieldDateTime:
+		switch x := v.(type) {
+		case time.Time:
+			return x, nil
+		case string:
+			return parseTime(x)
+		case []byte:
+			return parseTime(string(x))
+		}
+	case FieldBytes:
+		switch x := v.(type) {
+		case []byte:
+			return x, nil
+		case string:
+			return base64.StdEncoding.DecodeString(x)
+		}
+	default:
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case []byte:
+			return string(x), nil
+		}
+		return formatValue(v, valueType(v)), nil
+	}
+	return nil, fmt.Errorf("dataset: cannot convert %T to %s", v, t)
+}
+
+// 将值格式化为文本（导出用），日期时间为RFC3339格式，二进制为base64
+func formatValue(v interface{}, t FieldType) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case []byte:
+		if t == FieldBytes {
+			return base64.StdEncoding.EncodeToString(x)
+		}
+		return string(x)
+	}
+	return skit.String(v)
+}
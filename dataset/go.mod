@@ -0,0 +1,18 @@
+module ninego/dataset
+
+go 1.18.0
+
+require (
+	github.com/shopspring/decimal v1.4.0
+	ninego/expr v0.0.0-00010101000000-000000000000
+	ninego/skit v0.0.0-00010101000000-000000000000
+)
+
+require (
+	golang.org/x/net v0.11.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
+
+replace ninego/expr => ../expr
+
+replace ninego/skit => ../skit
@@ -0,0 +1,347 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// 聚合：fn为SUM、COUNT、AVG、MIN、MAX，field为"*"时(仅COUNT)统计记录数
+type aggregate struct {
+	fn    string
+	field string
+	name  string    //结果字段名
+	typ   FieldType //原字段类型
+}
+
+// 解析"SUM(Amount);COUNT(*);AVG(Price) AS AvgPrice"形式的聚合列表，未指定AS时结果字段名为"SUM_Amount"、"COUNT"等
+func (ds *DataSet) parseAggregates(aggregates string) ([]*aggregate, error) {
+	var aggs []*aggregate
+	for _, item := range splitKeys(aggregates) {
+		spec, name := item, ""
+		if i := strings.LastIndex(strings.ToUpper(item), " AS "); i >= 0 {
+			spec, name = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+4:])
+		}
+		open, close := strings.Index(spec, "("), strings.LastIndex(spec, ")")
+		if open <= 0 || close != len(spec)-1 {
+			return nil, fmt.Errorf("dataset: invalid aggregate %q", item)
+		}
+		agg := &aggregate{fn: strings.ToUpper(strings.TrimSpace(spec[:open])), field: strings.TrimSpace(spec[open+1 : close])}
+		if err := ds.checkAggregate(agg); err != nil {
+			return nil, err
+		}
+		if agg.name = name; name == "" {
+			agg.name = agg.fn
+			if agg.field != "*" {
+				agg.name += "_" + agg.field
+			}
+		}
+		aggs = append(aggs, agg)
+	}
+	return aggs, nil
+}
+
+// 检查聚合函数及字段，并确定原字段类型
+func (ds *DataSet) checkAggregate(agg *aggregate) error {
+	switch agg.fn {
+	case "SUM", "COUNT", "AVG", "MIN", "MAX":
+	default:
+		return fmt.Errorf("dataset: unknown aggregate function %s", agg.fn)
+	}
+	if agg.field == "*" || agg.field == "" {
+		if agg.fn != "COUNT" {
+			return fmt.Errorf("dataset: %s requires a field", agg.fn)
+		}
+		agg.field = "*"
+		return nil
+	}
+	if !ds.hasField(agg.field) {
+		return fmt.Errorf("dataset: field %s not found", agg.field)
+	}
+	agg.typ = ds.fieldType(agg.field)
+	if (agg.fn == "SUM" || agg.fn == "AVG") && !agg.typ.IsNumber() {
+		return fmt.Errorf("dataset: cannot %s %s field %s", agg.fn, agg.typ, agg.field)
+	}
+	return nil
+}
+
+// 结果字段的类型：COUNT为整数，SUM与原字段相同，AVG为浮点数（定点数仍为定点数），MIN/MAX与原字段相同
+func (agg *aggregate) resultType() FieldType {
+	switch agg.fn {
+	case "COUNT":
+		return FieldInteger
+	case "AVG":
+		if agg.typ == FieldDecimal {
+			return FieldDecimal
+		}
+		return FieldFloat
+	}
+	return agg.typ
+}
+
+func (agg *aggregate) fieldDef() *FieldDef {
+	return &FieldDef{Name: agg.name, Type: agg.resultType(), Nullable: agg.fn != "COUNT"}
+}
+
+// 聚合的中间结果
+type accumulator struct {
+	agg   *aggregate
+	count int64
+	ints  int64
+	float float64
+	dec   decimal.Decimal
+	value interface{} //MIN/MAX
+}
+
+// 累计一条记录，空值(NULL)跳过（COUNT(*)除外）
+func (a *accumulator) add(v interface{}) error {
+	if a.agg.field == "*" {
+		a.count++
+		return nil
+	}
+	if v == nil {
+		return nil
+	}
+	switch a.agg.fn {
+	case "SUM", "AVG":
+		var ok bool
+		switch a.agg.typ {
+		case FieldInteger:
+			var x int64
+			if x, ok = valueInt64(v); ok {
+				a.ints += x
+			}
+		case FieldDecimal:
+			var x decimal.Decimal
+			if x, ok = valueDecimal(v); ok {
+				a.dec = a.dec.Add(x)
+			}
+		default:
+			var x float64
+			if x, ok = valueFloat64(v); ok {
+				a.float += x
+			}
+		}
+		if !ok {
+			return fmt.Errorf("dataset: cannot %s %v (%T) of field %s", a.agg.fn, v, v, a.agg.field)
+		}
+	case "MIN":
+		if a.value == nil || compareField(v, a.value, a.agg.typ) < 0 {
+			a.value = v
+		}
+	case "MAX":
+		if a.value == nil || compareField(v, a.value, a.agg.typ) > 0 {
+			a.value = v
+		}
+	}
+	a.count++
+	return nil
+}
+
+// 聚合结果，没有非空值时为nil（COUNT为0）
+func (a *accumulator) result() interface{} {
+	if a.agg.fn == "COUNT" {
+		return a.count
+	}
+	if a.count == 0 {
+		return nil
+	}
+	switch a.agg.fn {
+	case "SUM":
+		switch a.agg.typ {
+		case FieldInteger:
+			return a.ints
+		case FieldDecimal:
+			return a.dec.String()
+		}
+		return a.float
+	case "AVG":
+		switch a.agg.typ {
+		case FieldInteger:
+			return float64(a.ints) / float64(a.count)
+		case FieldDecimal:
+			return a.dec.Div(decimal.NewFromInt(a.count)).String()
+		}
+		return a.float / float64(a.count)
+	}
+	return a.value
+}
+
+// 分组：按keyFields（多个字段用";"分隔）分组统计可见的记录，返回新的数据集。
+// aggregates为聚合列表，例如"SUM(Amount);COUNT(*);AVG(Price) AS AvgPrice"；结果字段名默认为"SUM_Amount"、"COUNT"等。
+// 空值(NULL)不参与统计，结果字段的类型按原字段类型确定（整数求和仍为整数，定点数仍为定点数）；结果字段名重复时返回错误。
+// 分组按首次出现的次序排列，需要时再用Sort排序。
+func (ds *DataSet) GroupBy(keyFields string, aggregates string) (*DataSet, error) {
+	keys := splitKeys(keyFields)
+	var defs []*FieldDef
+	names := map[string]bool{}
+	for _, key := range keys {
+		if !ds.hasField(key) {
+			return nil, fmt.Errorf("dataset: field %s not found", key)
+		}
+		if names[key] {
+			return nil, fmt.Errorf("dataset: duplicate field %s in GroupBy result", key)
+		}
+		names[key] = true
+		defs = append(defs, ds.keyFieldDef(key))
+	}
+	aggs, err := ds.parseAggregates(aggregates)
+	if err != nil {
+		return nil, err
+	}
+	for _, agg := range aggs {
+		if names[agg.name] {
+			return nil, fmt.Errorf("dataset: duplicate field %s in GroupBy result", agg.name)
+		}
+		names[agg.name] = true
+		defs = append(defs, agg.fieldDef())
+	}
+	ds.checkBrowseMode()
+
+	type group struct {
+		key  map[string]interface{}
+		accs []*accumulator
+	}
+	var groups []*group
+	lookup := map[string]*group{}
+	values := make([]interface{}, len(keys))
+	for i := 0; i < ds.recordCount; i++ {
+		rec := ds.record(i)
+		for k, key := range keys {
+			values[k] = ds.get(rec, key)
+		}
+		g := lookup[groupKey(values)]
+		if g == nil {
+			g = &group{key: make(map[string]interface{}, len(keys))}
+			for k, key := range keys {
+				g.key[key] = values[k]
+			}
+			for _, agg := range aggs {
+				g.accs = append(g.accs, &accumulator{agg: agg})
+			}
+			lookup[groupKey(values)] = g
+			groups = append(groups, g)
+		}
+		for _, acc := range g.accs {
+			if err := acc.add(ds.get(rec, acc.agg.field)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	records := make([]map[string]interface{}, len(groups))
+	for i, g := range groups {
+		rec := g.key
+		for _, acc := range g.accs {
+			rec[acc.agg.name] = acc.result()
+		}
+		records[i] = rec
+	}
+	return NewDataSet(defs, records), nil
+}
+
+// 分组字段的定义（复制原字段定义）
+func (ds *DataSet) keyFieldDef(name string) *FieldDef {
+	if def := ds.FieldDef(name); def != nil {
+		return dataFieldDef(def)
+	}
+	return &FieldDef{Name: name, Type: ds.fieldType(name), Nullable: true}
+}
+
+// 交叉表：按rowKey（多个字段用";"分隔）分行、colKey的每个值分列，单元格为valueField的聚合(agg为SUM、COUNT、AVG、MIN、MAX)。
+// 行按首次出现的次序排列，列按colKey的值排序，列名为colKey的值（空值为"NULL"）；没有数据的单元格为空值(COUNT为0)。
+func (ds *DataSet) Pivot(rowKey, colKey, valueField, agg string) (*DataSet, error) {
+	keys := splitKeys(rowKey)
+	var defs []*FieldDef
+	names := map[string]bool{}
+	for _, key := range keys {
+		if !ds.hasField(key) {
+			return nil, fmt.Errorf("dataset: field %s not found", key)
+		}
+		defs = append(defs, ds.keyFieldDef(key))
+		names[key] = true
+	}
+	if !ds.hasField(colKey) {
+		return nil, fmt.Errorf("dataset: field %s not found", colKey)
+	}
+	cell := &aggregate{fn: strings.ToUpper(strings.TrimSpace(agg)), field: valueField}
+	if err := ds.checkAggregate(cell); err != nil {
+		return nil, err
+	}
+	ds.checkBrowseMode()
+
+	//列：colKey的值排序
+	colType := ds.fieldType(colKey)
+	colIndex := map[string]int{}
+	var colValues []interface{}
+	for i := 0; i < ds.recordCount; i++ {
+		v := ds.get(ds.record(i), colKey)
+		if _, ok := colIndex[groupKey([]interface{}{v})]; !ok {
+			colIndex[groupKey([]interface{}{v})] = len(colValues)
+			colValues = append(colValues, v)
+		}
+	}
+	sort.SliceStable(colValues, func(a, b int) bool {
+		return compareField(colValues[a], colValues[b], colType) < 0
+	})
+	colNames := make([]string, len(colValues))
+	for i, v := range colValues {
+		colIndex[groupKey([]interface{}{v})] = i
+		base := "NULL"
+		if v != nil {
+			base = formatValue(v, colType)
+		}
+		name := base
+		for n := 1; names[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		names[name] = true
+		colNames[i] = name
+		def := cell.fieldDef()
+		def.Name = name
+		defs = append(defs, def)
+	}
+
+	//行
+	type row struct {
+		key  map[string]interface{}
+		accs []*accumulator
+	}
+	var rows []*row
+	lookup := map[string]*row{}
+	values := make([]interface{}, len(keys))
+	for i := 0; i < ds.recordCount; i++ {
+		rec := ds.record(i)
+		for k, key := range keys {
+			values[k] = ds.get(rec, key)
+		}
+		r := lookup[groupKey(values)]
+		if r == nil {
+			r = &row{key: make(map[string]interface{}, len(defs)), accs: make([]*accumulator, len(colValues))}
+			for k, key := range keys {
+				r.key[key] = values[k]
+			}
+			for c := range r.accs {
+				r.accs[c] = &accumulator{agg: cell}
+			}
+			lookup[groupKey(values)] = r
+			rows = append(rows, r)
+		}
+		c := colIndex[groupKey([]interface{}{ds.get(rec, colKey)})]
+		if err := r.accs[c].add(ds.get(rec, valueField)); err != nil {
+			return nil, err
+		}
+	}
+
+	records := make([]map[string]interface{}, len(rows))
+	for i, r := range rows {
+		rec := r.key
+		for c, acc := range r.accs {
+			rec[colNames[c]] = acc.result()
+		}
+		records[i] = rec
+	}
+	return NewDataSet(defs, records), nil
+}
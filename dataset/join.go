@@ -0,0 +1,134 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 连接方式
+type JoinKind int
+
+const (
+	InnerJoin JoinKind = iota //内连接：只保留两边都有的记录
+	LeftJoin                  //左连接：保留左边全部记录，右边没有对应记录时其字段为空(NULL)
+)
+
+// 连接两个数据集（内存中，按浏览次序，不访问数据库），返回新的数据集。
+// on为连接字段，多个字段用";"分隔，两边字段名不同时写作"左字段=右字段"，例如"OrderID"、"ID=OrderID;Line"。
+// 结果包含左边的全部字段及右边除同名连接字段外的字段，与左边重名的字段改名为"名称_1"。
+// 连接字段按字符串比较（与Locate相同），值为空(NULL)的记录不与任何记录连接。
+func Join(left, right *DataSet, on string, kind JoinKind) (*DataSet, error) {
+	var lkeys, rkeys []string
+	for _, item := range splitKeys(on) {
+		l, r := item, item
+		if i := strings.Index(item, "="); i >= 0 {
+			l, r = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		}
+		if !left.hasField(l) {
+			return nil, fmt.Errorf("dataset: join field %s not found in left", l)
+		}
+		if !right.hasField(r) {
+			return nil, fmt.Errorf("dataset: join field %s not found in right", r)
+		}
+		lkeys = append(lkeys, l)
+		rkeys = append(rkeys, r)
+	}
+	if len(lkeys) == 0 {
+		return nil, fmt.Errorf("dataset: no join fields")
+	}
+	left.checkBrowseMode()
+	right.checkBrowseMode()
+
+	//结果的字段定义
+	var defs []*FieldDef
+	names := map[string]bool{}
+	for _, def := range left.fieldDefs() {
+		defs = append(defs, dataFieldDef(def))
+		names[def.Name] = true
+	}
+	type rightField struct{ from, to string }
+	var rfields []rightField
+	for _, def := range right.fieldDefs() {
+		d := *dataFieldDef(def)
+		if names[d.Name] {
+			if isJoinKey(d.Name, lkeys, rkeys) {
+				continue
+			}
+			for n := 1; names[d.Name]; n++ {
+				d.Name = fmt.Sprintf("%s_%d", def.Name, n)
+			}
+		}
+		if kind == LeftJoin {
+			d.Nullable = true
+		}
+		defs = append(defs, &d)
+		names[d.Name] = true
+		rfields = append(rfields, rightField{def.Name, d.Name})
+	}
+
+	//右边按连接字段分组
+	values := make([]interface{}, len(rkeys))
+	groups := map[string][]map[string]interface{}{}
+	for i := 0; i < right.recordCount; i++ {
+		rec := right.record(i)
+		if !right.joinValues(rec, rkeys, values) {
+			continue
+		}
+		key := groupKey(values)
+		groups[key] = append(groups[key], rec)
+	}
+
+	records := make([]map[string]interface{}, 0, left.recordCount)
+	values = make([]interface{}, len(lkeys))
+	for i := 0; i < left.recordCount; i++ {
+		lrec := left.record(i)
+		var matches []map[string]interface{}
+		if left.joinValues(lrec, lkeys, values) {
+			matches = groups[groupKey(values)]
+		}
+		if len(matches) == 0 {
+			if kind == LeftJoin {
+				matches = []map[string]interface{}{nil}
+			} else {
+				continue
+			}
+		}
+		for _, rrec := range matches {
+			rec := copyRecord(lrec)
+			for name, fn := range left.virtual {
+				rec[name] = fn(lrec)
+			}
+			for _, f := range rfields {
+				var v interface{}
+				if rrec != nil {
+					v = right.get(rrec, f.from)
+				}
+				rec[f.to] = v
+			}
+			records = append(records, rec)
+		}
+	}
+	ds := NewDataSet(defs, records)
+	ds.Dialect = left.Dialect
+	return ds, nil
+}
+
+// 取连接字段的值，有空值时返回false
+func (ds *DataSet) joinValues(rec map[string]interface{}, keys []string, values []interface{}) bool {
+	for k, key := range keys {
+		if values[k] = ds.get(rec, key); values[k] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// 是否为两边同名的连接字段
+func isJoinKey(name string, lkeys, rkeys []string) bool {
+	for i := range lkeys {
+		if lkeys[i] == name && rkeys[i] == name {
+			return true
+		}
+	}
+	return false
+}
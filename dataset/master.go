@@ -0,0 +1,120 @@
+package db
+
+import "fmt"
+
+// 主从关系：明细数据集只显示与主数据集当前记录键值相同的记录
+type masterLink struct {
+	master       *DataSet
+	masterFields []string
+	detailFields []string
+	index        *index //明细数据集按detailFields的索引
+	key          string //主数据集当前记录的键值（见indexKey）
+	active       bool   //主数据集有当前记录（不在新增状态）
+}
+
+// 设置主数据集：主数据集移动记录指针时，本数据集(明细)自动过滤出detailFields与主数据集masterFields值相同的记录。
+// 多个字段用";"分隔，detailFields为空时与masterFields相同；master为nil时取消主从关系。
+// 明细数据集新增记录时，detailFields自动取主数据集当前记录的值。
+func (ds *DataSet) SetMaster(master *DataSet, masterFields, detailFields string) error {
+	ds.checkBrowseMode()
+	if ds.link != nil {
+		ds.link.master.removeDetail(ds)
+		ds.link = nil
+	}
+	if master == nil {
+		ds.keepCursor(ds.buildView)
+		return nil
+	}
+	for m := master; m != nil; m = m.Master() {
+		if m == ds {
+			return fmt.Errorf("dataset: circular master/detail link")
+		}
+	}
+	mkeys := splitKeys(masterFields)
+	dkeys := splitKeys(detailFields)
+	if len(dkeys) == 0 {
+		dkeys = mkeys
+	}
+	if len(mkeys) == 0 || len(mkeys) != len(dkeys) {
+		return fmt.Errorf("dataset: master fields %q do not match detail fields %q", masterFields, detailFields)
+	}
+	for i := range mkeys {
+		if !master.hasField(mkeys[i]) {
+			return fmt.Errorf("dataset: master field %s not found", mkeys[i])
+		}
+		if !ds.hasField(dkeys[i]) {
+			return fmt.Errorf("dataset: detail field %s not found", dkeys[i])
+		}
+	}
+	ds.link = &masterLink{master: master, masterFields: mkeys, detailFields: dkeys, index: &index{keys: dkeys}}
+	ds.link.index.build(ds.Records)
+	master.details = append(master.details, ds)
+	ds.syncMaster(true)
+	return nil
+}
+
+// 主数据集（没有主从关系时返回nil）
+func (ds *DataSet) Master() *DataSet {
+	if ds.link == nil {
+		return nil
+	}
+	return ds.link.master
+}
+
+func (ds *DataSet) removeDetail(detail *DataSet) {
+	for i, d := range ds.details {
+		if d == detail {
+			ds.details = append(ds.details[:i], ds.details[i+1:]...)
+			return
+		}
+	}
+}
+
+// 记录指针移动后，明细数据集按当前记录重新过滤
+func (ds *DataSet) scrolled() {
+	for _, d := range ds.details {
+		d.syncMaster(false)
+	}
+}
+
+// 按主数据集的当前记录重新过滤，键值未变且force为false时不处理
+func (ds *DataSet) syncMaster(force bool) {
+	l := ds.link
+	values, active := l.masterValues()
+	key := ""
+	if active {
+		key = indexKey(values)
+	}
+	if !force && active == l.active && key == l.key {
+		return
+	}
+	ds.checkBrowseMode()
+	l.key, l.active = key, active
+	ds.buildView()
+	ds.RecIndex = -1
+	ds.scrolled()
+	ds.changed("")
+}
+
+// 主数据集当前记录的键值，没有当前记录或正在新增时ok为false
+func (l *masterLink) masterValues() (values []interface{}, ok bool) {
+	m := l.master
+	i := m.recno()
+	if i < 0 || m.state == StateInsert {
+		return nil, false
+	}
+	rec := m.record(i)
+	values = make([]interface{}, len(l.masterFields))
+	for k, name := range l.masterFields {
+		values[k] = m.get(rec, name)
+	}
+	return values, true
+}
+
+// 与主数据集当前记录对应的记录（Records中的下标，从小到大）
+func (l *masterLink) rows() []int {
+	if !l.active {
+		return nil
+	}
+	return l.index.rows[l.key]
+}
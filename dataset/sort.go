@@ -0,0 +1,439 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ninego/expr"
+	"ninego/skit"
+)
+
+// 比较两个字段值，nil最小
+func compareField(a, b interface{}, t FieldType) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch {
+	case t.IsNumber():
+		if x, ok := a.(int64); ok {
+			if y, ok := b.(int64); ok {
+				return compareInt(x, y)
+			}
+		}
+		x, ok1 := toFloat(a)
+		y, ok2 := toFloat(b)
+		if ok1 && ok2 {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	case t == FieldDateTime:
+		if x, ok := a.(time.Time); ok {
+			if y, ok := b.(time.Time); ok {
+				switch {
+				case x.Before(y):
+					return -1
+				case x.After(y):
+					return 1
+				}
+				return 0
+			}
+		}
+	case t == FieldBoolean:
+		if x, ok := a.(bool); ok {
+			if y, ok := b.(bool); ok {
+				switch {
+				case x == y:
+					return 0
+				case y:
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+	return strings.Compare(skit.String(a), skit.String(b))
+}
+
+func compareInt(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(x)), 64)
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// 排序字段
+type sortField struct {
+	name string
+	desc bool
+	typ  FieldType
+}
+
+// 按字段排序，多个字段用";"分隔，字段名后可加DESC降序，例如"Name;Date DESC"。
+// 数值、日期按字段类型比较，空值(NULL)排在最前；fields为空时恢复原始次序。
+// 排序只改变记录的浏览次序，不改变Records。
+func (ds *DataSet) Sort(fields string) error {
+	var keys []sortField
+	for _, item := range splitKeys(fields) {
+		parts := strings.Fields(item)
+		key := sortField{name: parts[0]}
+		if len(parts) > 2 || (len(parts) == 2 && !strings.EqualFold(parts[1], "ASC") && !strings.EqualFold(parts[1], "DESC")) {
+			return fmt.Errorf("dataset: invalid sort field %q", item)
+		}
+		key.desc = len(parts) == 2 && strings.EqualFold(parts[1], "DESC")
+		if !ds.hasField(key.name) {
+			return fmt.Errorf("dataset: field %s not found", key.name)
+		}
+		key.typ = ds.fieldType(key.name)
+		keys = append(keys, key)
+	}
+	ds.checkBrowseMode()
+	ds.sortKeys = keys
+	ds.keepCursor(ds.buildView)
+	return nil
+}
+
+// 按条件过滤记录，fn返回false的记录不可见（不复制记录）；fn为nil时取消过滤
+func (ds *DataSet) SetFilter(fn func(record map[string]interface{}) bool) {
+	ds.checkBrowseMode()
+	ds.filter = fn
+	ds.keepCursor(ds.buildView)
+}
+
+// 按公式过滤记录（公式语法见expr，记录的字段名即变量名），例如"Age >= 18 and Status = 'A'"；
+// expression为空时取消过滤
+func (ds *DataSet) Filter(expression string) error {
+	if strings.TrimSpace(expression) == "" {
+		ds.SetFilter(nil)
+		return nil
+	}
+	prog, err := expr.Compile(expression)
+	if err != nil {
+		return err
+	}
+	for i, rec := range ds.Records {
+		if _, err := prog.EvalBool(ds.fullRecord(rec)); err != nil {
+			return fmt.Errorf("dataset: filter record %d: %w", i, err)
+		}
+	}
+	ds.SetFilter(func(record map[string]interface{}) bool {
+		ok, err := prog.EvalBool(record)
+		return err == nil && ok
+	})
+	return nil
+}
+
+// 直接修改Records后，重新排序、过滤并重建索引
+func (ds *DataSet) Refresh() {
+	ds.checkBrowseMode()
+	ds.alignStatus()
+	ds.indexDirty = true
+	ds.keepCursor(ds.buildView)
+	ds.changed("")
+}
+
+// 按排序及过滤条件生成浏览次序
+func (ds *DataSet) buildView() {
+	if !ds.viewActive() {
+		ds.setView(nil)
+		return
+	}
+	view := make([]int, 0, len(ds.Records))
+	if ds.link != nil {
+		//明细数据集只取与主数据集当前记录对应的记录
+		ds.updateIndexes()
+		for _, i := range ds.link.rows() {
+			if ds.filter == nil || ds.filter(ds.fullRecord(ds.Records[i])) {
+				view = append(view, i)
+			}
+		}
+	} else {
+		for i, rec := range ds.Records {
+			if ds.filter == nil || ds.filter(ds.fullRecord(rec)) {
+				view = append(view, i)
+			}
+		}
+	}
+	if len(ds.sortKeys) > 0 {
+		sort.SliceStable(view, func(a, b int) bool {
+			return ds.compareRows(view[a], view[b]) < 0
+		})
+	}
+	ds.setView(view)
+}
+
+func (ds *DataSet) compareRows(a, b int) int {
+	for _, key := range ds.sortKeys {
+		c := compareField(ds.get(ds.Records[a], key.name), ds.get(ds.Records[b], key.name), key.typ)
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (ds *DataSet) setView(view []int) {
+	ds.view = view
+	if view == nil {
+		ds.pos = nil
+		ds.recordCount = len(ds.Records)
+		return
+	}
+	ds.pos = make([]int, len(ds.Records))
+	for i := range ds.pos {
+		ds.pos[i] = -1
+	}
+	for p, r := range view {
+		ds.pos[r] = p
+	}
+	ds.recordCount = len(view)
+}
+
+// 是否已排序或过滤（含主从关系）
+func (ds *DataSet) viewActive() bool {
+	return len(ds.sortKeys) > 0 || ds.filter != nil || ds.link != nil
+}
+
+// 第i条（浏览次序）记录在Records中的下标
+func (ds *DataSet) row(i int) int {
+	if ds.view != nil {
+		return ds.view[i]
+	}
+	return i
+}
+
+// 第i条（浏览次序）记录
+func (ds *DataSet) record(i int) map[string]interface{} {
+	return ds.Records[ds.row(i)]
+}
+
+// Records中第r条记录的浏览次序，不可见时返回-1
+func (ds *DataSet) position(r int) int {
+	if ds.view != nil {
+		if r < 0 || r >= len(ds.pos) {
+			return -1
+		}
+		return ds.pos[r]
+	}
+	if r >= len(ds.Records) {
+		return -1
+	}
+	return r
+}
+
+// 执行fn后记录指针仍指向原记录（原记录不可见时移到首记录之前）
+func (ds *DataSet) keepCursor(fn func()) {
+	r := -1
+	if ds.RecIndex >= 0 && ds.RecIndex < ds.recordCount {
+		r = ds.row(ds.RecIndex)
+	}
+	fn()
+	ds.RecIndex = -1
+	if r >= 0 {
+		ds.RecIndex = ds.position(r)
+	}
+	ds.scrolled()
+}
+
+// 在Records的第r条之前插入记录，并在浏览次序的第p条之前显示
+func (ds *DataSet) insertRow(r, p int, rec map[string]interface{}, st *rowStatus) {
+	ds.alignStatus()
+	ds.Records = append(ds.Records, nil)
+	copy(ds.Records[r+1:], ds.Records[r:])
+	ds.Records[r] = rec
+	ds.status = append(ds.status, nil)
+	copy(ds.status[r+1:], ds.status[r:])
+	ds.status[r] = st
+	ds.indexDirty = true
+	if ds.view == nil {
+		ds.recordCount = len(ds.Records)
+		return
+	}
+	view := make([]int, 0, len(ds.view)+1)
+	for i, v := range ds.view {
+		if i == p {
+			view = append(view, r)
+		}
+		if v >= r {
+			v++
+		}
+		view = append(view, v)
+	}
+	if p >= len(ds.view) {
+		view = append(view, r)
+	}
+	ds.setView(view)
+}
+
+// 从Records中删除第r条记录
+func (ds *DataSet) removeRow(r int) {
+	ds.Records = append(ds.Records[:r], ds.Records[r+1:]...)
+	if r < len(ds.status) {
+		ds.status = append(ds.status[:r], ds.status[r+1:]...)
+	}
+	ds.indexDirty = true
+	if ds.view == nil {
+		ds.recordCount = len(ds.Records)
+		return
+	}
+	view := make([]int, 0, len(ds.view))
+	for _, v := range ds.view {
+		if v == r {
+			continue
+		}
+		if v > r {
+			v--
+		}
+		view = append(view, v)
+	}
+	ds.setView(view)
+}
+
+// 按字段建立索引，Locate按相同的字段查找时直接取索引（多个字段用";"分隔）
+func (ds *DataSet) AddIndex(keyFields string) error {
+	keys := splitKeys(keyFields)
+	if len(keys) == 0 {
+		return fmt.Errorf("dataset: no key fields")
+	}
+	for _, key := range keys {
+		if !ds.hasField(key) {
+			return fmt.Errorf("dataset: field %s not found", key)
+		}
+		if ds.isVirtual(key) {
+			return fmt.Errorf("dataset: cannot index calculated field %s", key)
+		}
+	}
+	if ds.indexes == nil {
+		ds.indexes = map[string]*index{}
+	}
+	idx := &index{keys: keys}
+	idx.build(ds.Records)
+	ds.indexes[strings.Join(keys, ";")] = idx
+	return nil
+}
+
+// 删除索引
+func (ds *DataSet) DropIndex(keyFields string) {
+	delete(ds.indexes, strings.Join(splitKeys(keyFields), ";"))
+}
+
+// 字段值 -> Records中的下标（按下标从小到大）
+type index struct {
+	keys []string
+	rows map[string][]int
+}
+
+func (idx *index) build(records []map[string]interface{}) {
+	idx.rows = make(map[string][]int, len(records))
+	values := make([]interface{}, len(idx.keys))
+	for i, rec := range records {
+		for k, key := range idx.keys {
+			values[k] = rec[key]
+		}
+		key := indexKey(values)
+		idx.rows[key] = append(idx.rows[key], i)
+	}
+}
+
+// 索引的键，与Locate相同按字符串比较
+func indexKey(values []interface{}) string {
+	var b strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(0)
+		}
+		b.WriteString(skit.String(v))
+	}
+	return b.String()
+}
+
+// 分组的键，与indexKey相同按字符串比较，但空值(NULL)与空字符串为不同的键
+func groupKey(values []interface{}) string {
+	var b strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(0)
+		}
+		if v == nil {
+			b.WriteByte(1)
+			continue
+		}
+		b.WriteByte(2)
+		b.WriteString(skit.String(v))
+	}
+	return b.String()
+}
+
+// 修改记录后重建索引
+func (ds *DataSet) updateIndexes() {
+	if !ds.indexDirty {
+		return
+	}
+	for _, idx := range ds.indexes {
+		idx.build(ds.Records)
+	}
+	if ds.link != nil {
+		ds.link.index.build(ds.Records)
+	}
+	ds.indexDirty = false
+}
+
+// 按索引查找第一条可见的记录，used为false表示没有可用的索引
+func (ds *DataSet) locateIndex(keyFields string, values []interface{}) (p int, used bool) {
+	keys := splitKeys(keyFields)
+	idx, ok := ds.indexes[strings.Join(keys, ";")]
+	if !ok || len(values) < len(keys) {
+		return -1, false
+	}
+	ds.updateIndexes()
+	p = -1
+	for _, r := range idx.rows[indexKey(values[:len(keys)])] {
+		if q := ds.position(r); q >= 0 && (p < 0 || q < p) {
+			p = q
+			if ds.view == nil {
+				break
+			}
+		}
+	}
+	return p, true
+}
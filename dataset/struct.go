@@ -0,0 +1,384 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// 数据集中没有对应结构体字段的字段（ToStructs的结果仍有效，可用errors.As判断后忽略）
+type UnmappedError struct {
+	Fields []string
+}
+
+func (e *UnmappedError) Error() string {
+	return "dataset: unmapped fields: " + strings.Join(e.Fields, ", ")
+}
+
+// 结构体字段与数据集字段的对应关系
+type structField struct {
+	name  string //数据集字段名
+	index []int  //reflect字段下标（含嵌入结构体）
+	typ   reflect.Type
+	depth int
+}
+
+var structFieldCache sync.Map //reflect.Type -> []structField
+
+var (
+	typeDecimal     = reflect.TypeOf(decimal.Decimal{})
+	typeNullDecimal = reflect.TypeOf(decimal.NullDecimal{})
+	typeScanner     = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	typeValuer      = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// 结构体的字段：字段名取`db:"name"`标签，其次`json:"name"`标签，再次字段名；标签为"-"的字段忽略。
+// 嵌入结构体（含指针）的字段视为外层的字段，同名时层次浅的优先。
+func structFields(t reflect.Type) []structField {
+	if fields, ok := structFieldCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	var all []structField
+	walkStruct(t, nil, 0, &all)
+	//同名字段保留层次最浅的（同层取先定义的）
+	best := map[string]int{}
+	for i, f := range all {
+		key := strings.ToLower(f.name)
+		if j, ok := best[key]; !ok || f.depth < all[j].depth {
+			best[key] = i
+		}
+	}
+	fields := make([]structField, 0, len(best))
+	for i, f := range all {
+		if best[strings.ToLower(f.name)] == i {
+			fields = append(fields, f)
+		}
+	}
+	structFieldCache.Store(t, fields)
+	return fields
+}
+
+func walkStruct(t reflect.Type, index []int, depth int, all *[]structField) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, tagged := fieldTag(sf)
+		if name == "-" {
+			continue
+		}
+		idx := append(append([]int(nil), index...), i)
+		if sf.Anonymous && !tagged {
+			et := sf.Type
+			if et.Kind() == reflect.Ptr {
+				if !sf.IsExported() {
+					continue //不能为未导出的嵌入指针分配内存
+				}
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct && !isValueStruct(et) {
+				walkStruct(et, idx, depth+1, all)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		*all = append(*all, structField{name: name, index: idx, typ: sf.Type, depth: depth})
+	}
+}
+
+// 字段名（db标签优先，其次json标签），tagged表示由标签指定
+func fieldTag(sf reflect.StructField) (name string, tagged bool) {
+	for _, key := range []string{"db", "json"} {
+		if tag, ok := sf.Tag.Lookup(key); ok {
+			if name = strings.TrimSpace(strings.Split(tag, ",")[0]); name != "" {
+				return name, true
+			}
+		}
+	}
+	return sf.Name, false
+}
+
+// 作为单个值的结构体（不展开其字段）
+func isValueStruct(t reflect.Type) bool {
+	return t == typeTime || t == typeDecimal || reflect.PtrTo(t).Implements(typeScanner) || t.Implements(typeValuer)
+}
+
+// 结构体类型（T可为结构体或结构体指针）
+func structType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, t.Kind() == reflect.Struct
+}
+
+// 把数据集的记录（按浏览次序，不移动记录指针）转换为结构体切片，T可为结构体或结构体指针。
+// 字段按db/json标签或字段名（忽略大小写）对应；数据集中没有对应结构体字段的字段以*UnmappedError返回。
+// 空值(NULL)：指针字段为nil，sql.NullString等类型Valid为false，其他字段为零值。
+func ToStructs[T any](ds *DataSet) ([]T, error) {
+	var zero T
+	rt := reflect.TypeOf(&zero).Elem()
+	st, ok := structType(rt)
+	if !ok {
+		return nil, fmt.Errorf("dataset: %s is not a struct", rt)
+	}
+
+	//数据集字段 -> 结构体字段
+	fields := structFields(st)
+	type mapping struct {
+		name  string
+		field structField
+	}
+	var maps []mapping
+	var unmapped []string
+	for _, def := range ds.fieldDefs() {
+		name := def.Name
+		found := false
+		for _, f := range fields {
+			if f.name == name {
+				maps, found = append(maps, mapping{name, f}), true
+				break
+			}
+		}
+		if !found {
+			for _, f := range fields {
+				if strings.EqualFold(f.name, name) {
+					maps, found = append(maps, mapping{name, f}), true
+					break
+				}
+			}
+		}
+		if !found {
+			unmapped = append(unmapped, name)
+		}
+	}
+
+	items := make([]T, ds.recordCount)
+	for i := range items {
+		v := reflect.ValueOf(&items[i]).Elem()
+		if rt.Kind() == reflect.Ptr {
+			v.Set(reflect.New(st))
+			v = v.Elem()
+		}
+		rec := ds.record(i)
+		for _, m := range maps {
+			if err := setField(fieldByIndex(v, m.field.index, true), ds.get(rec, m.name)); err != nil {
+				return nil, fmt.Errorf("dataset: record %d field %s: %w", i, m.name, err)
+			}
+		}
+	}
+	if len(unmapped) > 0 {
+		return items, &UnmappedError{Fields: unmapped}
+	}
+	return items, nil
+}
+
+// 按下标取字段，alloc为true时为nil的嵌入结构体指针分配内存，否则遇nil返回无效值
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// 为结构体字段赋值（按字段类型转换）
+func setField(fv reflect.Value, v interface{}) error {
+	ft := fv.Type()
+	if v == nil {
+		//sql.NullString等由Scan(nil)记录空值，不接受nil的Scanner（如decimal.Decimal）及其他字段为零值
+		if ft.Kind() != reflect.Ptr {
+			if s, ok := fv.Addr().Interface().(sql.Scanner); ok && s.Scan(nil) == nil {
+				return nil
+			}
+		}
+		fv.Set(reflect.Zero(ft))
+		return nil
+	}
+	if ft.Kind() != reflect.Ptr {
+		if s, ok := fv.Addr().Interface().(sql.Scanner); ok {
+			return s.Scan(v)
+		}
+	}
+	if ft.Kind() == reflect.Ptr {
+		p := reflect.New(ft.Elem())
+		if err := setField(p.Elem(), v); err != nil {
+			return err
+		}
+		fv.Set(p)
+		return nil
+	}
+	if ft == typeTime {
+		t, ok := valueTime(v)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to time.Time", v)
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	sv := reflect.ValueOf(v)
+	if sv.Type().AssignableTo(ft) {
+		fv.Set(sv)
+		return nil
+	}
+	ok := true
+	switch ft.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var x int64
+		if x, ok = valueInt64(v); ok {
+			if fv.OverflowInt(x) {
+				return fmt.Errorf("value %d overflows %s", x, ft)
+			}
+			fv.SetInt(x)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var x int64
+		if x, ok = valueInt64(v); ok {
+			if x < 0 || fv.OverflowUint(uint64(x)) {
+				return fmt.Errorf("value %d overflows %s", x, ft)
+			}
+			fv.SetUint(uint64(x))
+		}
+	case reflect.Float32, reflect.Float64:
+		var x float64
+		if x, ok = valueFloat64(v); ok {
+			fv.SetFloat(x)
+		}
+	case reflect.Bool:
+		var x bool
+		if x, ok = valueBool(v); ok {
+			fv.SetBool(x)
+		}
+	case reflect.String:
+		fv.SetString(formatValue(v, valueType(v)))
+	case reflect.Slice:
+		var x []byte
+		if x, ok = valueBytes(v); ok && ft.Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes(x)
+		} else {
+			ok = false
+		}
+	default:
+		if ok = sv.Type().ConvertibleTo(ft); ok {
+			fv.Set(sv.Convert(ft))
+		}
+	}
+	if !ok {
+		return fmt.Errorf("cannot convert %T to %s", v, ft)
+	}
+	return nil
+}
+
+// 由结构体切片创建数据集，T可为结构体或结构体指针（nil元素忽略）。
+// 字段定义按结构体字段的类型生成，指针字段及sql.NullString等类型的字段可为空(NULL)。
+func FromStructs[T any](items []T) (*DataSet, error) {
+	rt := reflect.TypeOf((*T)(nil)).Elem()
+	st, ok := structType(rt)
+	if !ok {
+		return nil, fmt.Errorf("dataset: %s is not a struct", rt)
+	}
+	fields := structFields(st)
+	defs := make([]*FieldDef, len(fields))
+	for i, f := range fields {
+		t, nullable := goFieldType(f.typ)
+		defs[i] = &FieldDef{Name: f.name, Type: t, Nullable: nullable}
+	}
+
+	records := make([]map[string]interface{}, 0, len(items))
+	for i := range items {
+		v := reflect.ValueOf(&items[i]).Elem()
+		if rt.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		rec := make(map[string]interface{}, len(fields))
+		for k, f := range fields {
+			x, err := fieldValue(fieldByIndex(v, f.index, false), defs[k].Type)
+			if err != nil {
+				return nil, fmt.Errorf("dataset: item %d field %s: %w", i, f.name, err)
+			}
+			rec[f.name] = x
+		}
+		records = append(records, rec)
+	}
+	return NewDataSet(defs, records), nil
+}
+
+// 结构体字段的值（转换为数据集的类型，见FieldType）
+func fieldValue(fv reflect.Value, t FieldType) (interface{}, error) {
+	if !fv.IsValid() {
+		return nil, nil
+	}
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil, nil
+		}
+		if !fv.Type().Implements(typeValuer) {
+			fv = fv.Elem()
+		}
+	}
+	v := fv.Interface()
+	if valuer, ok := v.(driver.Valuer); ok {
+		x, err := valuer.Value()
+		if err != nil {
+			return nil, err
+		}
+		v = x
+	}
+	return convertValue(v, t)
+}
+
+// 按Go类型确定字段类型，nullable表示可为空（指针或sql.Null*等类型）
+func goFieldType(t reflect.Type) (ft FieldType, nullable bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	switch t {
+	case typeTime:
+		return FieldDateTime, nullable
+	case typeNullTime:
+		return FieldDateTime, true
+	case typeDecimal:
+		return FieldDecimal, nullable
+	case typeNullDecimal:
+		return FieldDecimal, true
+	case reflect.TypeOf(sql.NullString{}):
+		return FieldString, true
+	case reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(sql.NullInt32{}), reflect.TypeOf(sql.NullInt16{}), reflect.TypeOf(sql.NullByte{}):
+		return FieldInteger, true
+	case reflect.TypeOf(sql.NullFloat64{}):
+		return FieldFloat, true
+	case reflect.TypeOf(sql.NullBool{}):
+		return FieldBoolean, true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return FieldInteger, nullable
+	case reflect.Float32, reflect.Float64:
+		return FieldFloat, nullable
+	case reflect.Bool:
+		return FieldBoolean, nullable
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return FieldBytes, true
+		}
+	}
+	return FieldString, nullable
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 生成SQL语句时的数据库方言
+type Dialect int
+
+const (
+	MySQL    Dialect = iota //占位符?，字段名`name`
+	Postgres                //占位符$1、$2...，字段名"name"
+	SQLite                  //占位符?，字段名"name"
+)
+
+// 第n个参数的占位符（从1开始）
+func (d Dialect) Placeholder(n int) string {
+	if d == Postgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// 引用字段名
+func (d Dialect) Quote(name string) string {
+	if d == MySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// 引用表名，"schema.table"形式的各部分分别引用
+func (d Dialect) QuoteTable(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = d.Quote(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ".")
+}
+
+// 可执行SQL的数据库连接：*sql.DB或*sql.Tx
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// 一条修改对应的SQL语句
+type Statement struct {
+	Change Change
+	SQL    string
+	Args   []interface{}
+}
+
+// 修改或删除时影响的记录数为0（记录已被他人修改或删除）
+type Conflict struct {
+	Statement Statement
+}
+
+// 保存修改失败，Conflicts为冲突的记录（已回滚）
+type UpdateError struct {
+	Conflicts []Conflict
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("dataset: %d update conflict(s), first: %s", len(e.Conflicts), e.Conflicts[0].Statement.SQL)
+}
+
+// 按待保存的修改生成INSERT/UPDATE/DELETE语句（带参数），keyFields为主键字段，多个字段用";"分隔。
+// 表名及字段名按Dialect引用，table可为"schema.table"形式。
+// 修改只更新值有变化的字段，删除及修改按主键字段的原值定位记录；新增时值为nil的字段不插入（使用数据库默认值），全部为nil时插入全部为默认值的记录。
+func (ds *DataSet) UpdateStatements(table string, keyFields string) ([]Statement, error) {
+	keys := splitKeys(keyFields)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("dataset: no key fields")
+	}
+	for _, key := range keys {
+		if !ds.hasField(key) {
+			return nil, fmt.Errorf("dataset: key field %s not found", key)
+		}
+	}
+
+	if strings.TrimSpace(table) == "" {
+		return nil, fmt.Errorf("dataset: no table name")
+	}
+	table = ds.Dialect.QuoteTable(table)
+
+	names := ds.fieldNames()
+	var stmts []Statement
+	for _, ch := range ds.Changes() {
+		b := &sqlBuilder{dialect: ds.Dialect}
+		switch ch.Status {
+		case StatusInserted:
+			var cols, params []string
+			for _, name := range names {
+				if v := ch.Record[name]; v != nil {
+					cols = append(cols, ds.Dialect.Quote(name))
+					params = append(params, b.arg(v))
+				}
+			}
+			switch {
+			case len(cols) > 0:
+				b.sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(cols, ", "), strings.Join(params, ", "))
+			case ds.Dialect == MySQL:
+				b.sql = fmt.Sprintf("INSERT INTO %s () VALUES ()", table)
+			default:
+				b.sql = fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", table)
+			}
+		case StatusModified:
+			var sets []string
+			for _, name := range names {
+				if !reflect.DeepEqual(ch.Record[name], ch.Original[name]) {
+					sets = append(sets, ds.Dialect.Quote(name)+" = "+b.arg(ch.Record[name]))
+				}
+			}
+			if len(sets) == 0 {
+				continue
+			}
+			b.sql = fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(sets, ", "), b.where(keys, ch.Original))
+		case StatusDeleted:
+			b.sql = fmt.Sprintf("DELETE FROM %s WHERE %s", table, b.where(keys, ch.Original))
+		}
+		stmts = append(stmts, Statement{Change: ch, SQL: b.sql, Args: b.args})
+	}
+	return stmts, nil
+}
+
+// 将待保存的修改写入数据库table表。db为*sql.DB时在一个事务中执行，全部成功才提交；
+// db为*sql.Tx时由调用方提交或回滚。修改或删除的记录不存在时返回*UpdateError（列出全部冲突的记录）。
+// 成功后清除修改记录(CommitUpdates)。
+func (ds *DataSet) ApplyUpdates(db Execer, table string, keyFields string) (err error) {
+	ds.checkBrowseMode()
+	stmts, err := ds.UpdateStatements(table, keyFields)
+	if err != nil || len(stmts) == 0 {
+		return err
+	}
+
+	exec := db
+	if conn, ok := db.(*sql.DB); ok {
+		var tx *sql.Tx
+		if tx, err = conn.Begin(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+		exec = tx
+	}
+
+	var conflicts []Conflict
+	for _, stmt := range stmts {
+		res, err := exec.Exec(stmt.SQL, stmt.Args...)
+		if err != nil {
+			return fmt.Errorf("dataset: %s: %w", stmt.SQL, err)
+		}
+		if stmt.Change.Status != StatusInserted {
+			if n, err := res.RowsAffected(); err == nil && n == 0 {
+				conflicts = append(conflicts, Conflict{Statement: stmt})
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return &UpdateError{Conflicts: conflicts}
+	}
+	ds.CommitUpdates()
+	return nil
+}
+
+type sqlBuilder struct {
+	dialect Dialect
+	sql     string
+	args    []interface{}
+}
+
+// 添加参数，返回占位符
+func (b *sqlBuilder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return b.dialect.Placeholder(len(b.args))
+}
+
+// 按主键字段的原值定位记录
+func (b *sqlBuilder) where(keys []string, original map[string]interface{}) string {
+	conds := make([]string, len(keys))
+	for i, key := range keys {
+		if v := original[key]; v == nil {
+			conds[i] = b.dialect.Quote(key) + " IS NULL"
+		} else {
+			conds[i] = b.dialect.Quote(key) + " = " + b.arg(v)
+		}
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// 拆分"A;B"形式的字段列表
+func splitKeys(keyFields string) []string {
+	var keys []string
+	for _, key := range strings.Split(keyFields, ";") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
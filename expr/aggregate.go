@@ -0,0 +1,132 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// 按数据集计算时的聚合函数：只有1个参数(count可无参数)时对所有记录聚合，否则按普通函数计算
+var aggregates = map[string]bool{
+	"sum":   true,
+	"count": true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+}
+
+func isAggregate(node *CallExpression) bool {
+	if !aggregates[node.Function] {
+		return false
+	}
+	return len(node.Arguments) == 1 || (node.Function == "count" && len(node.Arguments) == 0)
+}
+
+// 逐条记录计算公式（记录的字段名即变量名），records即dataset.DataSet的Records。
+// val为公式中用到的其他变量，传参规则同Calc（记录中的字段优先）。
+func (p *Program) EvalRecords(records []map[string]interface{}, val ...interface{}) ([]Value, error) {
+	values := make([]Value, len(records))
+	for i, record := range records {
+		s := p.bind(append([]interface{}{record}, val...)...)
+		values[i] = p.run(s)
+		if s.err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, s.err)
+		}
+	}
+	return values, nil
+}
+
+// 逐条记录计算公式，并将结果作为name字段追加到每条记录中（数值为decimal.Decimal）
+func (p *Program) AppendColumn(records []map[string]interface{}, name string, val ...interface{}) error {
+	values, err := p.EvalRecords(records, val...)
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		record[name] = values[i].Interface()
+	}
+	return nil
+}
+
+// 按整个数据集计算含聚合函数的公式，例如 SUM(Amount*Rate)/COUNT()。
+// 聚合函数的参数按每条记录计算，聚合函数之外的变量从val中取值。
+func (p *Program) Aggregate(records []map[string]interface{}, val ...interface{}) (Value, error) {
+	s := p.bind(val...)
+	s.rows = make([]*scope, len(records))
+	for i, record := range records {
+		s.rows[i] = p.bind(append([]interface{}{record}, val...)...)
+	}
+	v := p.run(s)
+	if s.err != nil {
+		return NullValue, s.err
+	}
+	return v, nil
+}
+
+// 编译并按整个数据集计算公式，见Program.Aggregate
+func Aggregate(input string, records []map[string]interface{}, val ...interface{}) (Value, error) {
+	prog, err := Compile(input)
+	if err != nil {
+		return NullValue, err
+	}
+	return prog.Aggregate(records, val...)
+}
+
+func evalAggregate(s *scope, node *CallExpression) Value {
+	if len(node.Arguments) == 0 {
+		return NumberValue(decimal.NewFromInt(int64(len(s.rows))))
+	}
+
+	var (
+		count int64
+		sum   = zero
+		ret   = NullValue
+	)
+	for i, row := range s.rows {
+		row.locals = s.locals //脚本中赋值的变量按整个数据集计算
+		v := eval(node.Arguments[0], row)
+		if row.err != nil {
+			s.error(row.err.Kind, node.Token, fmt.Sprintf("record %d: %s", i, row.err.Msg))
+			return NullValue
+		}
+		if v.IsNull() {
+			continue
+		}
+		count++
+		switch node.Function {
+		case "sum", "avg":
+			d, ok := v.Decimal()
+			if !ok {
+				s.error(ErrInvalidValue, node.Token, fmt.Sprintf("record %d: %s %q is not a number", i, v.Kind(), v.String()))
+				return NullValue
+			}
+			sum = sum.Add(d)
+		case "min", "max":
+			if ret.IsNull() {
+				ret = v
+				continue
+			}
+			c, ok := compareValues(v, ret)
+			if !ok {
+				s.error(ErrInvalidValue, node.Token, fmt.Sprintf("record %d: cannot compare %s with %s", i, v.Kind(), ret.Kind()))
+				return NullValue
+			}
+			if (node.Function == "min" && c < 0) || (node.Function == "max" && c > 0) {
+				ret = v
+			}
+		}
+	}
+
+	switch node.Function {
+	case "count":
+		return NumberValue(decimal.NewFromInt(count))
+	case "sum":
+		return NumberValue(sum)
+	case "avg":
+		if count == 0 {
+			return NullValue
+		}
+		return NumberValue(sum.Div(decimal.NewFromInt(count)))
+	}
+	return ret
+}
@@ -0,0 +1,353 @@
+package expr
+
+import (
+	"bytes"
+	"strings"
+)
+
+// 遍历语法树时对每个节点调用Visit，返回的w不为nil时继续用w遍历该节点的子节点，
+// 子节点遍历完后再调用w.Visit(nil)（同go/ast）
+type Visitor interface {
+	Visit(node Expression) (w Visitor)
+}
+
+// 深度优先遍历语法树
+func Walk(v Visitor, node Expression) {
+	if node == nil {
+		return
+	}
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	for _, child := range children(node) {
+		Walk(v, child)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(Expression) bool
+
+func (f inspector) Visit(node Expression) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// 深度优先遍历语法树，f返回false时不再遍历该节点的子节点；子节点遍历完后调用f(nil)
+func Inspect(node Expression, f func(Expression) bool) {
+	Walk(inspector(f), node)
+}
+
+// 节点的子节点
+func children(node Expression) []Expression {
+	switch n := node.(type) {
+	case *PrefixExpression:
+		return []Expression{n.Right}
+	case *InfixExpression:
+		return []Expression{n.Left, n.Right}
+	case *CallExpression:
+		return n.Arguments
+	case *MemberExpression:
+		return []Expression{n.Object}
+	case *IndexExpression:
+		return []Expression{n.Left, n.Index}
+	case *AssignExpression:
+		return []Expression{n.Value}
+	case *ScriptExpression:
+		return n.Statements
+	}
+	return nil
+}
+
+// 表达式中引用的变量名（按首次出现的次序，不含?及脚本中赋值的变量；成员访问只取根变量名）
+func Vars(exp Expression) []string {
+	var names []string
+	seen := map[string]bool{}
+	Inspect(exp, func(node Expression) bool {
+		if ident, ok := node.(*IdentExpression); ok && ident.Name != "?" && !ident.Local && !seen[ident.Name] {
+			seen[ident.Name] = true
+			names = append(names, ident.Name)
+		}
+		return true
+	})
+	return names
+}
+
+// 计算结果只与参数有关的内置函数，参数为常量时可在编译期计算
+var pureFuncs = map[string]bool{
+	"abs": true, "sum": true, "avg": true, "count": true, "min": true, "max": true,
+	"round": true, "ceil": true, "floor": true, "days": true, "date": true,
+	"year": true, "month": true, "day": true,
+	"len": true, "upper": true, "lower": true, "trim": true,
+}
+
+// 常量折叠：将不含变量的子表达式替换为计算结果（返回新的语法树，不修改exp）。
+// 计算出错的子表达式（如1/0）保持原样，以便求值时报告出错位置。
+func Simplify(exp Expression) Expression {
+	e, _ := simplify(exp)
+	return e
+}
+
+// 返回化简后的表达式，及其是否为常量
+func simplify(exp Expression) (Expression, bool) {
+	switch node := exp.(type) {
+	case *FloatLiteralExpression, *StringLiteralExpression, *BoolLiteralExpression:
+		return node, true
+
+	case *PrefixExpression:
+		right, c := simplify(node.Right)
+		n := &PrefixExpression{Token: node.Token, Operator: node.Operator, Right: right}
+		if c {
+			return fold(n)
+		}
+		return n, false
+
+	case *InfixExpression:
+		left, lc := simplify(node.Left)
+		right, rc := simplify(node.Right)
+		n := &InfixExpression{Token: node.Token, Left: left, Operator: node.Operator, Right: right}
+		if lc && rc {
+			return fold(n)
+		}
+		//短路：false && x、true || x
+		if lc && (node.Operator == AND || node.Operator == OR) {
+			if v, ok := constValue(left); ok && v.Bool() == (node.Operator == OR) {
+				return literal(node.Token, BoolValue(v.Bool())), true
+			}
+		}
+		return n, false
+
+	case *CallExpression:
+		n := &CallExpression{Token: node.Token, Function: node.Function, fn: node.fn}
+		allConst := true
+		for _, arg := range node.Arguments {
+			a, c := simplify(arg)
+			n.Arguments = append(n.Arguments, a)
+			allConst = allConst && c
+		}
+		if n.Function == "if" {
+			if v, ok := constValue(n.Arguments[0]); ok {
+				if v.Bool() {
+					return simplify(n.Arguments[1])
+				}
+				return simplify(n.Arguments[2])
+			}
+			return n, false
+		}
+		if allConst && pureFuncs[n.Function] && lookupFunc(n.Function) != nil {
+			return fold(n)
+		}
+		return n, false
+
+	case *MemberExpression:
+		object, _ := simplify(node.Object)
+		return &MemberExpression{Token: node.Token, Object: object, Name: node.Name}, false
+
+	case *IndexExpression:
+		left, _ := simplify(node.Left)
+		index, _ := simplify(node.Index)
+		return &IndexExpression{Token: node.Token, Left: left, Index: index}, false
+
+	case *AssignExpression:
+		value, _ := simplify(node.Value)
+		return &AssignExpression{Token: node.Token, Name: node.Name, Value: value, Index: node.Index}, false
+
+	case *ScriptExpression:
+		n := &ScriptExpression{Token: node.Token}
+		for _, stmt := range node.Statements {
+			stmt, _ = simplify(stmt)
+			n.Statements = append(n.Statements, stmt)
+		}
+		return n, false
+	}
+	return exp, false
+}
+
+// 计算常量表达式，出错或结果不能表示为常量（日期、null）时保持原样
+func fold(exp Expression) (Expression, bool) {
+	s := &scope{}
+	v := eval(exp, s)
+	if s.err != nil {
+		return exp, false
+	}
+	switch v.Kind() {
+	case NumberKind, StringKind, BoolKind:
+		return literal(tokenOf(exp), v), true
+	}
+	return exp, false
+}
+
+func constValue(exp Expression) (Value, bool) {
+	switch node := exp.(type) {
+	case *FloatLiteralExpression:
+		return NumberValue(node.Value), true
+	case *StringLiteralExpression:
+		return StringValue(node.Value), true
+	case *BoolLiteralExpression:
+		return BoolValue(node.Value), true
+	}
+	return NullValue, false
+}
+
+// 生成常量节点，位置取自原表达式
+func literal(t Token, v Value) Expression {
+	switch v.Kind() {
+	case StringKind:
+		return &StringLiteralExpression{Token: Token{Type: STR, Literal: quote(v.str), Pos: t.Pos}, Value: v.str}
+	case BoolKind:
+		tok := Token{Type: FALSE, Literal: "false", Pos: t.Pos}
+		if v.b {
+			tok.Type, tok.Literal = TRUE, "true"
+		}
+		return &BoolLiteralExpression{Token: tok, Value: v.b}
+	}
+	return &FloatLiteralExpression{Token: Token{Type: NUM, Literal: v.num.String(), Pos: t.Pos}, Value: v.num}
+}
+
+func tokenOf(exp Expression) Token {
+	switch node := exp.(type) {
+	case *FloatLiteralExpression:
+		return node.Token
+	case *StringLiteralExpression:
+		return node.Token
+	case *BoolLiteralExpression:
+		return node.Token
+	case *IdentExpression:
+		return node.Token
+	case *PrefixExpression:
+		return node.Token
+	case *InfixExpression:
+		return node.Token
+	case *CallExpression:
+		return node.Token
+	case *MemberExpression:
+		return node.Token
+	case *IndexExpression:
+		return node.Token
+	case *AssignExpression:
+		return node.Token
+	case *ScriptExpression:
+		return node.Token
+	}
+	return Token{}
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// 规范化输出公式：运算符两侧各一个空格，只保留必要的括号，逻辑运算符写作and/or/not，函数名小写
+func Format(exp Expression) string {
+	var out bytes.Buffer
+	format(&out, exp)
+	return out.String()
+}
+
+// 规范格式中的运算符写法
+var operatorText = map[string]string{
+	AND: "and",
+	OR:  "or",
+	NOT: "not ",
+}
+
+func precedenceOf(exp Expression) int {
+	switch node := exp.(type) {
+	case *InfixExpression:
+		return precedences[node.Operator]
+	case *PrefixExpression:
+		switch node.Operator {
+		case NOT:
+			return LNOT
+		case MINUS:
+			return PREFIX
+		}
+	case *FloatLiteralExpression:
+		if node.Value.IsNegative() {
+			return PREFIX
+		}
+	}
+	return CALL
+}
+
+func format(out *bytes.Buffer, exp Expression) {
+	switch node := exp.(type) {
+	case *FloatLiteralExpression:
+		out.WriteString(node.Value.String())
+	case *StringLiteralExpression:
+		out.WriteString(quote(node.Value))
+	case *BoolLiteralExpression:
+		if node.Value {
+			out.WriteString("true")
+		} else {
+			out.WriteString("false")
+		}
+	case *IdentExpression:
+		out.WriteString(node.Name)
+	case *PrefixExpression:
+		if node.Operator == LVERT {
+			out.WriteString("[")
+			format(out, node.Right)
+			out.WriteString("]")
+			return
+		}
+		if text, ok := operatorText[node.Operator]; ok {
+			out.WriteString(text)
+		} else {
+			out.WriteString(node.Operator)
+		}
+		formatOperand(out, node.Right, precedenceOf(node.Right) < precedenceOf(node))
+	case *InfixExpression:
+		p := precedenceOf(node)
+		formatOperand(out, node.Left, precedenceOf(node.Left) < p)
+		out.WriteString(" ")
+		if text, ok := operatorText[node.Operator]; ok {
+			out.WriteString(text)
+		} else {
+			out.WriteString(node.Operator)
+		}
+		out.WriteString(" ")
+		//运算符都是左结合的，右侧同级运算须加括号
+		formatOperand(out, node.Right, precedenceOf(node.Right) <= p)
+	case *CallExpression:
+		out.WriteString(node.Function)
+		out.WriteString("(")
+		for i, arg := range node.Arguments {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			format(out, arg)
+		}
+		out.WriteString(")")
+	case *MemberExpression:
+		formatOperand(out, node.Object, precedenceOf(node.Object) < CALL)
+		out.WriteString(".")
+		out.WriteString(node.Name)
+	case *IndexExpression:
+		formatOperand(out, node.Left, precedenceOf(node.Left) < CALL)
+		out.WriteString("[")
+		format(out, node.Index)
+		out.WriteString("]")
+	case *AssignExpression:
+		out.WriteString(node.Name)
+		out.WriteString(" = ")
+		format(out, node.Value)
+	case *ScriptExpression:
+		for i, stmt := range node.Statements {
+			if i > 0 {
+				out.WriteString("; ")
+			}
+			format(out, stmt)
+		}
+	}
+}
+
+func formatOperand(out *bytes.Buffer, exp Expression, paren bool) {
+	if paren {
+		out.WriteString("(")
+	}
+	format(out, exp)
+	if paren {
+		out.WriteString(")")
+	}
+}
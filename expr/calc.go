@@ -3,8 +3,11 @@ package expr
 import (
 	"bytes"
 	"fmt"
-	"reflect"
+	"math"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/shopspring/decimal"
 )
@@ -28,19 +31,65 @@ const (
 
 	LPAREN = "(" //括号
 	RPAREN = ")" //括号
+	COMMA  = "," //函数参数分隔
+	DOT    = "." //成员访问，如order.Customer.Level
+
+	SEMICOLON = ";" //语句分隔，如 a = x*2; b = a+1; b
+
+	STR       = "STR"   //字符串，用单引号或双引号括起，引号本身须写两次
+	TRUE      = "TRUE"  //true
+	FALSE     = "FALSE" //false
+	AMPERSAND = "&"     //字符串连接
+
+	EQ     = "="  //等于(也可写作==)
+	NOT_EQ = "<>" //不等于(也可写作!=)
+	LT     = "<"  //小于
+	LTE    = "<=" //小于等于
+	GT     = ">"  //大于
+	GTE    = ">=" //大于等于
+	AND    = "&&" //与(也可写作and)
+	OR     = "||" //或(也可写作or)
+	NOT    = "!"  //非(也可写作not)
 )
 
+// 逻辑运算的单词形式(不区分大小写)
+var keywords = map[string]string{
+	"and": AND,
+	"or":  OR,
+	"not": NOT,
+}
+
+// 布尔常量(不区分大小写)
+var literals = map[string]string{
+	"true":  TRUE,
+	"false": FALSE,
+}
+
 const (
 	_ int = iota
 	LOWEST
+	LOR     // ||
+	LAND    // &&
+	LNOT    // !X
+	COMPARE // =, <>, <, <=, >, >=
+	CONCAT  // &
 	SUM     // +, -
 	PRODUCT // *, /, %, |
 	PREFIX  // -X
 	POW     // ^
-	CALL    // (X), |X|
+	CALL    // (X), |X|, fn(X), a.b, a[i]
 )
 
 var precedences = map[string]int{
+	OR:        LOR,
+	AND:       LAND,
+	EQ:        COMPARE,
+	NOT_EQ:    COMPARE,
+	LT:        COMPARE,
+	LTE:       COMPARE,
+	GT:        COMPARE,
+	GTE:       COMPARE,
+	AMPERSAND: CONCAT,
 	PLUS:      SUM,
 	MINUS:     SUM,
 	SLASH:     PRODUCT,
@@ -49,71 +98,15 @@ var precedences = map[string]int{
 	PERCENT:   PRODUCT,
 	POWER:     POW,
 	LVERT:     CALL,
-	LPAREN:    CALL,
+	DOT:       CALL,
 }
 
 func Calc(input string, val ...interface{}) float64 {
-	lexer := NewLex(input)
-	parser := NewParser(lexer)
-
-	idx := 0
-	for /*i*/ _, m := range val {
-		v := reflect.ValueOf(m)
-		t := v.Type()
-		for t.Kind() == reflect.Ptr {
-			v = v.Elem()
-			t = v.Type()
-		}
-		if t.Kind() == reflect.Map {
-			for _, k := range reflect.Indirect(v).MapKeys() {
-				v1 := reflect.ValueOf(reflect.Indirect(v).MapIndex(k).Interface())
-				t1 := v1.Type()
-				for t1.Kind() == reflect.Ptr {
-					v1 = v1.Elem()
-					t1 = v1.Type()
-				}
-				if t1.Kind() == reflect.Struct {
-					for n := 0; n < t1.NumField(); n++ {
-						if v1.Field(n).CanInterface() {
-							v2 := reflect.ValueOf(v1.Field(n).Interface())
-							t2 := v2.Type()
-							for t2.Kind() == reflect.Ptr {
-								v2 = v2.Elem()
-								t2 = v2.Type()
-							}
-							float_num, _ := strconv.ParseFloat(fmt.Sprint(v2.Interface()), 64)
-							parser.v[t1.Field(n).Name] = float_num
-						}
-					}
-				} else {
-					float_num, _ := strconv.ParseFloat(fmt.Sprint(v1.Interface()), 64)
-					parser.v[k.String()] = float_num
-				}
-			}
-		} else if t.Kind() == reflect.Struct {
-			for n := 0; n < t.NumField(); n++ {
-				if v.Field(n).CanInterface() {
-					v2 := reflect.ValueOf(v.Field(n).Interface())
-					t2 := v2.Type()
-					for t2.Kind() == reflect.Ptr {
-						v2 = v2.Elem()
-						t2 = v2.Type()
-					}
-					float_num, _ := strconv.ParseFloat(fmt.Sprint(v2.Interface()), 64)
-					parser.v[t.Field(n).Name] = float_num
-				}
-			}
-		} else {
-			float_num, _ := strconv.ParseFloat(fmt.Sprint(v.Interface()), 64)
-			parser.v[fmt.Sprintf("@%v", idx)] = float_num
-			idx++
-		}
+	prog, err := Compile(input)
+	if err != nil {
+		return 0
 	}
-	//fmt.Println(parser.v)
-
-	exp := parser.ParseExpression(LOWEST)
-	fmt.Println(exp)
-	f, exact := Eval(exp).Float64()
+	f, exact := prog.Eval(val...).Float64()
 	if exact {
 		return f
 	}
@@ -121,78 +114,198 @@ func Calc(input string, val ...interface{}) float64 {
 	return float_num
 }
 
+// 计算条件公式，结果不为0即为true
+func CalcBool(input string, val ...interface{}) (bool, error) {
+	prog, err := Compile(input)
+	if err != nil {
+		return false, err
+	}
+	return prog.EvalBool(val...)
+}
+
+// 同Calc，公式有误或计算出错时返回*Error
+func CalcE(input string, val ...interface{}) (float64, error) {
+	prog, err := Compile(input)
+	if err != nil {
+		return 0, err
+	}
+	d, err := prog.EvalE(val...)
+	if err != nil {
+		return 0, err
+	}
+	f, exact := d.Float64()
+	if math.IsInf(f, 0) {
+		return 0, &Error{Kind: ErrOverflow, Pos: 1, Msg: "result out of float64 range"}
+	}
+	if exact {
+		return f, nil
+	}
+	float_num, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'f', decimal.DivisionPrecision, 64), 64)
+	return float_num, nil
+}
+
 var zero = decimal.Zero
 
+// 布尔值参与算术运算时：真为1，假为0
+var trueV = decimal.NewFromInt(1)
+
+// 乘方运算允许的最大指数
+const maxPowExponent = 10000
+
 func Eval(exp Expression) decimal.Decimal {
+	d, _ := eval(exp, &scope{}).Decimal()
+	return d
+}
+
+// 同Eval，计算出错或结果不是数值时返回*Error
+func EvalE(exp Expression) (decimal.Decimal, error) {
+	s := &scope{}
+	return s.result(eval(exp, s))
+}
+
+// s为变量取值作用域，计算出错时记录第1个错误并按0继续计算
+func eval(exp Expression, s *scope) Value {
 	switch node := exp.(type) {
 	case *FloatLiteralExpression:
-		return node.Value
+		return NumberValue(node.Value)
+	case *StringLiteralExpression:
+		return StringValue(node.Value)
+	case *BoolLiteralExpression:
+		return BoolValue(node.Value)
+	case *IdentExpression:
+		return s.get(node)
 	case *PrefixExpression:
-		rightV := Eval(node.Right)
-		return evalPrefixExpression(node.Operator, rightV)
+		rightV := eval(node.Right, s)
+		return evalPrefixExpression(s, node, rightV)
 	case *InfixExpression:
-		leftV := Eval(node.Left)
-		rightV := Eval(node.Right)
-		return evalInfixExpression(leftV, node.Operator, rightV)
+		leftV := eval(node.Left, s)
+		//逻辑运算短路求值
+		switch {
+		case node.Operator == AND && !leftV.Bool():
+			return BoolValue(false)
+		case node.Operator == OR && leftV.Bool():
+			return BoolValue(true)
+		}
+		rightV := eval(node.Right, s)
+		return evalInfixExpression(s, node, leftV, rightV)
+	case *CallExpression:
+		return evalCallExpression(s, node)
+	case *MemberExpression, *IndexExpression:
+		return s.path(exp)
+	case *AssignExpression:
+		v := eval(node.Value, s)
+		s.setLocal(node.Index, v)
+		return v
+	case *ScriptExpression:
+		var v Value
+		for _, stmt := range node.Statements {
+			v = eval(stmt, s)
+		}
+		return v
 	}
 
-	return zero //decimal.Zero
+	return NumberValue(zero)
 }
 
-func evalPrefixExpression(operator string, right decimal.Decimal) decimal.Decimal {
-	if operator == "-" {
-		return right.Neg()
+func evalPrefixExpression(s *scope, node *PrefixExpression, right Value) Value {
+	switch node.Operator {
+	case MINUS:
+		return NumberValue(s.number(node.Token, right).Neg())
+	case LVERT:
+		return NumberValue(s.number(node.Token, right).Abs())
+	case NOT:
+		return BoolValue(!right.Bool())
 	}
-	if operator == "[" {
-		return right.Abs()
-	}
-	return zero //decimal.Zero
+	return NumberValue(zero)
 }
 
-func evalInfixExpression(left decimal.Decimal, operator string, right decimal.Decimal) decimal.Decimal {
+func evalInfixExpression(s *scope, node *InfixExpression, left, right Value) Value {
+
+	switch node.Operator {
+	case AMPERSAND:
+		return StringValue(left.String() + right.String())
+	case EQ, NOT_EQ:
+		c, ok := compareValues(left, right)
+		return BoolValue((ok && c == 0) == (node.Operator == EQ))
+	case LT, LTE, GT, GTE:
+		c, ok := compareValues(left, right)
+		if !ok {
+			s.error(ErrInvalidValue, node.Token, fmt.Sprintf("cannot compare %s with %s", left.Kind(), right.Kind()))
+			return BoolValue(false)
+		}
+		switch node.Operator {
+		case LT:
+			return BoolValue(c < 0)
+		case LTE:
+			return BoolValue(c <= 0)
+		case GT:
+			return BoolValue(c > 0)
+		}
+		return BoolValue(c >= 0)
+	case AND, OR:
+		return BoolValue(right.Bool())
+	case PLUS, MINUS:
+		//日期运算：日期-日期=相差天数，日期±天数=日期
+		if left.Kind() == DateKind && right.Kind() == DateKind && node.Operator == MINUS {
+			return NumberValue(daysBetween(left.t, right.t))
+		}
+		if left.Kind() == DateKind && right.Kind() != DateKind {
+			days := s.number(node.Token, right)
+			if node.Operator == MINUS {
+				days = days.Neg()
+			}
+			return DateValue(addDays(left.t, days))
+		}
+		if right.Kind() == DateKind && node.Operator == PLUS {
+			return DateValue(addDays(right.t, s.number(node.Token, left)))
+		}
+	}
 
-	switch operator {
-	case "+":
-		return left.Add(right)
-	case "-":
-		return left.Sub(right)
-	case "*":
-		return left.Mul(right)
-	case "/":
-		if right.String() != "0" {
-			return left.Div(right)
-		} else {
-			return zero //decimal.Zero
+	l, r := s.number(node.Token, left), s.number(node.Token, right)
+	switch node.Operator {
+	case PLUS:
+		return s.arith(l.Add(r))
+	case MINUS:
+		return s.arith(l.Sub(r))
+	case ASTERISK:
+		return s.arith(l.Mul(r))
+	case SLASH:
+		if !r.IsZero() {
+			return s.arith(s.div(l, r))
 		}
-	case "|":
-		if right.String() != "0" {
-			return left.Div(right).Truncate(0)
-		} else {
-			return zero //decimal.Zero
+	case BACKSLASH:
+		if !r.IsZero() {
+			return NumberValue(l.Div(r).Truncate(0))
 		}
-	case "%":
-		if right.String() != "0" {
-			return left.Mod(right)
-		} else {
-			return zero //decimal.Zero
+	case PERCENT:
+		if !r.IsZero() {
+			return s.arith(l.Mod(r))
+		}
+	case POWER:
+		if r.Abs().GreaterThan(decimal.NewFromInt(maxPowExponent)) {
+			s.error(ErrOverflow, node.Token, fmt.Sprintf("exponent %s is too large", r))
+			return NumberValue(zero)
+		}
+		if l.IsZero() && r.IsNegative() {
+			s.error(ErrDivideByZero, node.Token, "zero raised to a negative power")
+			return NumberValue(zero)
+		}
+		if l.IsNegative() && !r.IsInteger() {
+			s.error(ErrInvalidValue, node.Token, "negative number raised to a fractional power")
+			return NumberValue(zero)
 		}
-	case "^":
-		return left.Pow(right)
+		return s.arith(l.Pow(r))
 	default:
-		return zero //decimal.Zero
+		return NumberValue(zero)
 	}
+	s.error(ErrDivideByZero, node.Token, "division by zero")
+	return NumberValue(zero)
 }
 
 type Token struct {
 	Type    string
 	Literal string
-}
-
-func newToken(tokenType string, c byte) Token {
-	return Token{
-		Type:    tokenType,
-		Literal: string(c),
-	}
+	Pos     int //在公式中的位置（字节偏移）
 }
 
 type Lexer struct {
@@ -212,30 +325,81 @@ func (l *Lexer) NextToken() Token {
 	var tok Token
 
 	l.skipWhitespace()
+	tok.Pos = l.position
 
 	switch l.ch {
 	case '(':
-		tok = newToken(LPAREN, l.ch)
+		tok.Type, tok.Literal = LPAREN, string(l.ch)
 	case ')':
-		tok = newToken(RPAREN, l.ch)
+		tok.Type, tok.Literal = RPAREN, string(l.ch)
+	case ',':
+		tok.Type, tok.Literal = COMMA, string(l.ch)
+	case '.':
+		tok.Type, tok.Literal = DOT, string(l.ch)
+	case ';':
+		tok.Type, tok.Literal = SEMICOLON, string(l.ch)
+	case '\'', '"':
+		var ok bool
+		tok.Type = STR
+		if tok.Literal, ok = l.readString(); !ok {
+			tok.Type = ILLEGAL
+		}
+		return tok
 	case '+':
-		tok = newToken(PLUS, l.ch)
+		tok.Type, tok.Literal = PLUS, string(l.ch)
 	case '-':
-		tok = newToken(MINUS, l.ch)
+		tok.Type, tok.Literal = MINUS, string(l.ch)
 	case '*':
-		tok = newToken(ASTERISK, l.ch)
+		tok.Type, tok.Literal = ASTERISK, string(l.ch)
 	case '/':
-		tok = newToken(SLASH, l.ch)
+		tok.Type, tok.Literal = SLASH, string(l.ch)
 	case '|':
-		tok = newToken(BACKSLASH, l.ch)
+		if l.peekChar() == '|' {
+			tok.Type, tok.Literal = OR, l.readTwoChar()
+		} else {
+			tok.Type, tok.Literal = BACKSLASH, string(l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok.Type, tok.Literal = AND, l.readTwoChar()
+		} else {
+			tok.Type, tok.Literal = AMPERSAND, string(l.ch)
+		}
+	case '!':
+		if l.peekChar() == '=' {
+			tok.Type, tok.Literal = NOT_EQ, l.readTwoChar()
+		} else {
+			tok.Type, tok.Literal = NOT, string(l.ch)
+		}
+	case '=':
+		if l.peekChar() == '=' {
+			tok.Type, tok.Literal = EQ, l.readTwoChar()
+		} else {
+			tok.Type, tok.Literal = EQ, string(l.ch)
+		}
+	case '<':
+		switch l.peekChar() {
+		case '=':
+			tok.Type, tok.Literal = LTE, l.readTwoChar()
+		case '>':
+			tok.Type, tok.Literal = NOT_EQ, l.readTwoChar()
+		default:
+			tok.Type, tok.Literal = LT, string(l.ch)
+		}
+	case '>':
+		if l.peekChar() == '=' {
+			tok.Type, tok.Literal = GTE, l.readTwoChar()
+		} else {
+			tok.Type, tok.Literal = GT, string(l.ch)
+		}
 	case '%':
-		tok = newToken(PERCENT, l.ch)
+		tok.Type, tok.Literal = PERCENT, string(l.ch)
 	case '^':
-		tok = newToken(POWER, l.ch)
+		tok.Type, tok.Literal = POWER, string(l.ch)
 	case '[':
-		tok = newToken(LVERT, l.ch)
+		tok.Type, tok.Literal = LVERT, string(l.ch)
 	case ']':
-		tok = newToken(RVERT, l.ch)
+		tok.Type, tok.Literal = RVERT, string(l.ch)
 	case 0:
 		tok.Literal = ""
 		tok.Type = EOF
@@ -249,12 +413,22 @@ func (l *Lexer) NextToken() Token {
 			tok.Type = NUM
 			tok.Literal = l.readNumber()
 			return tok
-		} else if isMacroVal(l.ch) {
+		} else if r, size := utf8.DecodeRuneInString(l.input[l.position:]); isMacroVal(r) {
 			tok.Type = VAL
 			tok.Literal = l.readMacroVal()
+			if t, ok := keywords[strings.ToLower(tok.Literal)]; ok {
+				tok.Type = t
+			} else if t, ok := literals[strings.ToLower(tok.Literal)]; ok {
+				tok.Type = t
+			}
 			return tok
 		} else {
-			tok = newToken(ILLEGAL, l.ch)
+			//非ASCII字符按整个字符报错（如全角括号）
+			tok.Type, tok.Literal = ILLEGAL, l.input[l.position:l.position+size]
+			for i := 0; i < size; i++ {
+				l.readChar()
+			}
+			return tok
 		}
 	}
 
@@ -276,6 +450,39 @@ func (l *Lexer) readChar() {
 	l.readPosition += 1
 }
 
+func (l *Lexer) peekChar() byte {
+	if l.readPosition >= len(l.input) {
+		return 0
+	}
+	return l.input[l.readPosition]
+}
+
+// 读取两个字符的运算符（返回后由NextToken再前进一个字符）
+func (l *Lexer) readTwoChar() string {
+	ch := l.ch
+	l.readChar()
+	return string(ch) + string(l.ch)
+}
+
+// 读取字符串（含两端引号），ok为false表示缺少结束引号
+func (l *Lexer) readString() (string, bool) {
+	position := l.position
+	quote := l.ch
+	for {
+		l.readChar()
+		if l.ch == 0 && l.position >= len(l.input) {
+			return l.input[position:l.position], false
+		}
+		if l.ch == quote {
+			if l.peekChar() != quote {
+				l.readChar()
+				return l.input[position:l.position], true
+			}
+			l.readChar()
+		}
+	}
+}
+
 func (l *Lexer) readNumber() string {
 	position := l.position
 	for isDigit(l.ch) {
@@ -291,19 +498,22 @@ func (l *Lexer) readNumber() string {
 	return l.input[position:l.position]
 }
 
-func isMacroVal(ch byte) bool {
-	return ('a' <= ch && ch <= 'z') ||
-		('A' <= ch && ch <= 'Z') ||
-		(ch == '_') || (ch > 127)
+// 变量名以字母（含中文等Unicode字母）或下划线开头
+func isMacroVal(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
 }
 
+// 读取变量名：字母、数字、下划线，如 单价、税率_2024年、x1y
 func (l *Lexer) readMacroVal() string {
 	position := l.position
-	for isMacroVal(l.ch) {
-		l.readChar()
-	}
-	for isDigit(l.ch) {
-		l.readChar()
+	for l.ch != 0 {
+		r, size := utf8.DecodeRuneInString(l.input[l.position:])
+		if !isMacroVal(r) && !unicode.IsDigit(r) {
+			break
+		}
+		for i := 0; i < size; i++ {
+			l.readChar()
+		}
 	}
 
 	return l.input[position:l.position]
@@ -328,6 +538,31 @@ type FloatLiteralExpression struct {
 
 func (il *FloatLiteralExpression) String() string { return il.Token.Literal }
 
+type StringLiteralExpression struct {
+	Token Token
+	Value string
+}
+
+func (sl *StringLiteralExpression) String() string { return sl.Token.Literal }
+
+type BoolLiteralExpression struct {
+	Token Token
+	Value bool
+}
+
+func (bl *BoolLiteralExpression) String() string { return bl.Token.Literal }
+
+// 变量(或?占位符)，Index为其在公式中出现的次序；
+// Local为true表示脚本中前面语句赋值的变量，Index为其存储位置
+type IdentExpression struct {
+	Token Token
+	Name  string
+	Index int
+	Local bool
+}
+
+func (ie *IdentExpression) String() string { return ie.Token.Literal }
+
 type PrefixExpression struct {
 	Token    Token
 	Operator string
@@ -351,6 +586,53 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+// 函数调用
+type CallExpression struct {
+	Token     Token
+	Function  string //函数名（小写）
+	Arguments []Expression
+
+	fn ValueFunc
+}
+
+func (ce *CallExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ce.Token.Literal)
+	out.WriteString("(")
+	for i, arg := range ce.Arguments {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(arg.String())
+	}
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// 成员访问：Object.Name（struct的Field或tag、map的key）
+type MemberExpression struct {
+	Token  Token
+	Object Expression
+	Name   string
+}
+
+func (me *MemberExpression) String() string {
+	return me.Object.String() + "." + me.Name
+}
+
+// 下标访问：Left[Index]（slice/array的序号、map的key）
+type IndexExpression struct {
+	Token Token
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) String() string {
+	return ie.Left.String() + "[" + ie.Index.String() + "]"
+}
+
 type InfixExpression struct {
 	Token    Token
 	Left     Expression
@@ -372,6 +654,32 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// 赋值语句：Name = Value（只用于脚本中最后一条语句之前）
+type AssignExpression struct {
+	Token Token //变量名
+	Name  string
+	Value Expression
+	Index int //变量的存储位置
+}
+
+func (ae *AssignExpression) String() string {
+	return ae.Name + " = " + ae.Value.String()
+}
+
+// 多条语句的脚本，按次序执行，最后一条语句的值即结果
+type ScriptExpression struct {
+	Token      Token
+	Statements []Expression
+}
+
+func (se *ScriptExpression) String() string {
+	parts := make([]string, len(se.Statements))
+	for i, stmt := range se.Statements {
+		parts[i] = stmt.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
 // parser
 type (
 	prefixParseFn func() Expression
@@ -379,16 +687,16 @@ type (
 )
 
 type Parser struct {
-	l     *Lexer
-	v     map[string]float64
-	index int
+	l      *Lexer
+	idents []*IdentExpression
+	locals map[string]int //脚本中已赋值的变量及其存储位置
 
 	curToken  Token
 	peekToken Token
 
 	prefixParseFns map[string]prefixParseFn
 
-	errors []string
+	errors []*Error
 }
 
 func (p *Parser) registerPrefix(tokenType string, fn prefixParseFn) {
@@ -398,16 +706,18 @@ func (p *Parser) registerPrefix(tokenType string, fn prefixParseFn) {
 func NewParser(l *Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		v:      map[string]float64{},
-		index:  0,
-		errors: []string{},
+		errors: []*Error{},
 	}
 
 	p.prefixParseFns = make(map[string]prefixParseFn)
 	p.registerPrefix(NUM, p.parseFloatLiteral)
+	p.registerPrefix(STR, p.parseStringLiteral)
+	p.registerPrefix(TRUE, p.parseBoolLiteral)
+	p.registerPrefix(FALSE, p.parseBoolLiteral)
 	p.registerPrefix(VAL, p.parseMacroValLiteral)
 	p.registerPrefix(PLUS, p.parsePreNullExpression)
 	p.registerPrefix(MINUS, p.parsePrefixExpression)
+	p.registerPrefix(NOT, p.parsePrefixExpression)
 	p.registerPrefix(LVERT, p.parseAbsExpression)
 	p.registerPrefix(LPAREN, p.parseGroupedExpression)
 
@@ -419,9 +729,13 @@ func NewParser(l *Lexer) *Parser {
 
 func (p *Parser) ParseExpression(precedence int) Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
+	if prefix == nil {
+		p.noPrefixParseFnError(p.curToken)
+		return nil
+	}
 	returnExp := prefix()
 
-	for precedence < p.peekPrecedence() {
+	for returnExp != nil && precedence < p.peekPrecedence() {
 		p.nextToken()
 		returnExp = p.parseInfixExpression(returnExp)
 	}
@@ -443,10 +757,104 @@ func (p *Parser) curPrecedence() int {
 	return LOWEST
 }
 
+func (p *Parser) error(kind ErrorKind, t Token, msg string) {
+	p.errors = append(p.errors, newError(kind, p.l.input, t, msg))
+}
+
 func (p *Parser) peekError(t string) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instend",
+	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.error(ErrSyntax, p.peekToken, msg)
+}
+
+func (p *Parser) noPrefixParseFnError(t Token) {
+	switch t.Type {
+	case EOF:
+		p.error(ErrSyntax, t, "unexpected end of expression")
+	case ILLEGAL:
+		if t.Literal != "" && (t.Literal[0] == '\'' || t.Literal[0] == '"') {
+			p.error(ErrSyntax, t, "unterminated string")
+		} else {
+			p.error(ErrSyntax, t, fmt.Sprintf("illegal character %q", t.Literal))
+		}
+	default:
+		p.error(ErrSyntax, t, fmt.Sprintf("unexpected token %q", t.Literal))
+	}
+}
+
+// 解析公式或以";"分隔的多条语句：最后一条之前的语句须为赋值语句(变量名 = 表达式)，
+// 最后一条语句为结果表达式。只有一条语句时返回该表达式本身，其中的"="仍为等于。
+func (p *Parser) ParseScript() Expression {
+	script := &ScriptExpression{Token: p.curToken}
+	for p.moreStatements() {
+		stmt := p.parseAssignment()
+		if stmt == nil {
+			return nil
+		}
+		script.Statements = append(script.Statements, stmt)
+		for p.curTokenIs(SEMICOLON) {
+			p.nextToken()
+		}
+	}
+	exp := p.ParseExpression(LOWEST)
+	if exp == nil {
+		return nil
+	}
+	for p.peekTokenIs(SEMICOLON) {
+		p.nextToken()
+	}
+	p.expectEnd()
+	if len(script.Statements) == 0 {
+		return exp
+	}
+	script.Statements = append(script.Statements, exp)
+	return script
+}
+
+// 当前语句之后是否还有语句（向后查找";"，不移动解析位置）
+func (p *Parser) moreStatements() bool {
+	l := *p.l
+	sep := false
+	for t := p.peekToken; t.Type != EOF; t = l.NextToken() {
+		if t.Type == SEMICOLON {
+			sep = true
+		} else if sep {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) parseAssignment() Expression {
+	name := p.curToken
+	if !p.curTokenIs(VAL) || name.Literal == "?" || !p.peekTokenIs(EQ) || p.peekToken.Literal != "=" {
+		p.error(ErrSyntax, name, "expected assignment (name = expression) before ';'")
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+	value := p.ParseExpression(LOWEST)
+	if value == nil || !p.expectPeek(SEMICOLON) {
+		return nil
+	}
+
+	if p.locals == nil {
+		p.locals = map[string]int{}
+	}
+	index, ok := p.locals[name.Literal]
+	if !ok {
+		index = len(p.locals)
+		p.locals[name.Literal] = index
+	}
+	return &AssignExpression{Token: name, Name: name.Literal, Value: value, Index: index}
+}
+
+// 公式须完整解析，不允许有多余的内容
+func (p *Parser) expectEnd() {
+	if !p.peekTokenIs(EOF) {
+		p.nextToken()
+		p.noPrefixParseFnError(p.curToken)
+	}
 }
 
 func (p *Parser) expectPeek(t string) bool {
@@ -459,6 +867,10 @@ func (p *Parser) expectPeek(t string) bool {
 	}
 }
 
+func (p *Parser) curTokenIs(t string) bool {
+	return p.curToken.Type == t
+}
+
 func (p *Parser) peekTokenIs(t string) bool {
 	return p.peekToken.Type == t
 }
@@ -468,60 +880,106 @@ func (p *Parser) nextToken() {
 	p.peekToken = p.l.NextToken()
 }
 
+func (p *Parser) parseStringLiteral() Expression {
+	lit := p.curToken.Literal
+	quote := lit[:1]
+	value := strings.ReplaceAll(lit[1:len(lit)-1], quote+quote, quote)
+	return &StringLiteralExpression{Token: p.curToken, Value: value}
+}
+
+func (p *Parser) parseBoolLiteral() Expression {
+	return &BoolLiteralExpression{Token: p.curToken, Value: p.curTokenIs(TRUE)}
+}
+
 func (p *Parser) parseFloatLiteral() Expression {
 
 	lit := &FloatLiteralExpression{Token: p.curToken}
 
-	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	value, err := decimal.NewFromString(p.curToken.Literal)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		msg := fmt.Sprintf("could not parse %q as number", p.curToken.Literal)
+		p.error(ErrSyntax, p.curToken, msg)
 		return nil
 	}
 
-	lit.Value = decimal.NewFromFloat(value)
+	lit.Value = value
 	return lit
 }
 
 func (p *Parser) parseMacroValLiteral() Expression {
+	if p.curToken.Literal != "?" && p.peekTokenIs(LPAREN) {
+		return p.parseCallExpression()
+	}
+	if index, ok := p.locals[p.curToken.Literal]; ok {
+		return &IdentExpression{Token: p.curToken, Name: p.curToken.Literal, Index: index, Local: true}
+	}
+	ident := &IdentExpression{
+		Token: p.curToken,
+		Name:  p.curToken.Literal,
+		Index: len(p.idents),
+	}
+	p.idents = append(p.idents, ident)
+	return ident
+}
 
-	lit := &FloatLiteralExpression{Token: p.curToken}
-
-	value, has := p.v[lit.Token.Literal]
-	if !has {
-		value2, has := p.v[fmt.Sprintf("@%v", p.index)]
-		if !has {
-			msg := fmt.Sprintf("could not parse %q as macro value", p.curToken.Literal)
-			p.errors = append(p.errors, msg)
+func (p *Parser) parseCallExpression() Expression {
+	exp := &CallExpression{
+		Token:    p.curToken,
+		Function: strings.ToLower(p.curToken.Literal),
+	}
+	if exp.Function != "if" {
+		if exp.fn = lookupFunc(exp.Function); exp.fn == nil {
+			p.error(ErrUnknownFunc, p.curToken, fmt.Sprintf("unknown function %s", p.curToken.Literal))
 			return nil
 		}
-		if lit.Token.Literal != "?" {
-			delete(p.v, fmt.Sprintf("@%v", p.index))
-			p.v[lit.Token.Literal] = value2
+	}
+	p.nextToken()
+
+	if p.peekTokenIs(RPAREN) {
+		p.nextToken()
+	} else {
+		for {
+			p.nextToken()
+			arg := p.ParseExpression(LOWEST)
+			if arg == nil {
+				return nil
+			}
+			exp.Arguments = append(exp.Arguments, arg)
+			if !p.peekTokenIs(COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(RPAREN) {
+			return nil
 		}
-		value = value2
-		p.index++
 	}
-	//fmt.Println(p.index, p.curToken, value)
 
-	lit.Value = decimal.NewFromFloat(value)
-	return lit
+	if exp.Function == "if" && len(exp.Arguments) != 3 {
+		p.error(ErrArgument, exp.Token, "if expects 3 arguments")
+		return nil
+	}
+	return exp
 }
 
 func (p *Parser) parsePrefixExpression() Expression {
 
 	expression := &PrefixExpression{
 		Token:    p.curToken,
-		Operator: p.curToken.Literal,
+		Operator: p.curToken.Type,
+	}
+	precedence := PREFIX
+	if expression.Operator == NOT {
+		precedence = LNOT
 	}
 	p.nextToken()
-	expression.Right = p.ParseExpression(PREFIX)
+	expression.Right = p.ParseExpression(precedence)
 	return expression
 }
 
 func (p *Parser) parsePreNullExpression() Expression {
 	p.nextToken()
-	exp := p.ParseExpression(LOWEST)
+	exp := p.ParseExpression(PREFIX)
 	return exp
 }
 
@@ -550,10 +1008,16 @@ func (p *Parser) parseGroupedExpression() Expression {
 }
 
 func (p *Parser) parseInfixExpression(left Expression) Expression {
+	switch p.curToken.Type {
+	case DOT:
+		return p.parseMemberExpression(left)
+	case LVERT:
+		return p.parseIndexExpression(left)
+	}
 
 	expression := &InfixExpression{
 		Token:    p.curToken,
-		Operator: p.curToken.Literal,
+		Operator: p.curToken.Type,
 		Left:     left,
 	}
 
@@ -565,6 +1029,32 @@ func (p *Parser) parseInfixExpression(left Expression) Expression {
 	return expression
 }
 
+func (p *Parser) parseMemberExpression(object Expression) Expression {
+	exp := &MemberExpression{Token: p.curToken, Object: object}
+	if !p.expectPeek(VAL) || p.curToken.Literal == "?" {
+		if p.curTokenIs(VAL) {
+			p.noPrefixParseFnError(p.curToken)
+		}
+		return nil
+	}
+	exp.Name = p.curToken.Literal
+	return exp
+}
+
+func (p *Parser) parseIndexExpression(left Expression) Expression {
+	exp := &IndexExpression{Token: p.curToken, Left: left}
+	p.nextToken()
+	exp.Index = p.ParseExpression(LOWEST)
+	if exp.Index == nil || !p.expectPeek(RVERT) {
+		return nil
+	}
+	return exp
+}
+
 func (p *Parser) Errors() []string {
-	return p.errors
+	msgs := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		msgs[i] = err.Error()
+	}
+	return msgs
 }
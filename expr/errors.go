@@ -0,0 +1,65 @@
+package expr
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// 错误类型
+type ErrorKind int
+
+const (
+	ErrSyntax       ErrorKind = iota + 1 //公式语法错误
+	ErrUnknownVar                        //变量未赋值
+	ErrDivideByZero                      //除数为0
+	ErrOverflow                          //数值溢出
+	ErrInvalidValue                      //变量值无法转换为数值，或运算无意义
+	ErrUnknownFunc                       //函数未定义
+	ErrArgument                          //函数参数个数或取值有误
+	ErrAmbiguousVar                      //变量名有歧义（map中多个struct有同名Field）
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrSyntax:
+		return "syntax error"
+	case ErrUnknownVar:
+		return "unknown variable"
+	case ErrDivideByZero:
+		return "divide by zero"
+	case ErrOverflow:
+		return "overflow"
+	case ErrInvalidValue:
+		return "invalid value"
+	case ErrUnknownFunc:
+		return "unknown function"
+	case ErrArgument:
+		return "invalid argument"
+	case ErrAmbiguousVar:
+		return "ambiguous variable"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// 公式解析或计算错误
+type Error struct {
+	Kind  ErrorKind
+	Pos   int    //出错位置（从1开始的列号，按字符计）
+	Token string //出错位置的单词，为空表示公式末尾
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at column %d: %s", e.Kind, e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%s at column %d near %q: %s", e.Kind, e.Pos, e.Token, e.Msg)
+}
+
+func newError(kind ErrorKind, input string, t Token, msg string) *Error {
+	pos := t.Pos + 1
+	if t.Pos <= len(input) {
+		pos = utf8.RuneCountInString(input[:t.Pos]) + 1
+	}
+	return &Error{Kind: kind, Pos: pos, Token: t.Literal, Msg: msg}
+}
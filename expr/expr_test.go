@@ -0,0 +1,518 @@
+package expr
+
+import (
+	"database/sql"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCalc(t *testing.T) {
+	type item struct {
+		Price float64
+		Qty   int
+	}
+	cases := []struct {
+		input string
+		val   []interface{}
+		want  float64
+	}{
+		{"1+2*3", nil, 7},
+		{"(1+2)*3", nil, 9},
+		{"-2^2", nil, -4},
+		{"[3-5]*2", nil, 4},
+		{"7|2+7%2", nil, 4},
+		{"(?+?)/?-?", []interface{}{1, 3, 2, 1}, 1},
+		{"a+b+a+c", []interface{}{1, 2, 3}, 7},
+		{"Price*Qty", []interface{}{item{2.5, 4}}, 10},
+		{"a*b-c", []interface{}{map[string]interface{}{"a": 2, "b": 3}, 1}, 5},
+	}
+	for _, c := range cases {
+		if got := Calc(c.input, c.val...); got != c.want {
+			t.Errorf("Calc(%q) = %v, want %v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	prog, err := Compile("a*b+a-?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars := prog.Vars(); len(vars) != 2 || vars[0] != "a" || vars[1] != "b" {
+		t.Errorf("Vars() = %v", vars)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got := prog.Eval(map[string]interface{}{"a": i, "b": 2}, 1)
+			if want := int64(i*2 + i - 1); got.IntPart() != want {
+				t.Errorf("Eval(a=%d) = %v, want %v", i, got, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, input := range []string{"", "1+", "(1+2", "*3"} {
+		if _, err := Compile(input); err == nil {
+			t.Errorf("Compile(%q) expected error", input)
+		}
+	}
+}
+
+func TestCalcE(t *testing.T) {
+	cases := []struct {
+		input string
+		val   []interface{}
+		kind  ErrorKind
+		pos   int
+	}{
+		{"1+*2", nil, ErrSyntax, 3},
+		{"(1+2", nil, ErrSyntax, 5},
+		{"1+2)", nil, ErrSyntax, 4},
+		{"1 # 2", nil, ErrSyntax, 3},
+		{"a+b", []interface{}{1}, ErrUnknownVar, 3},
+		{"a*2", []interface{}{map[string]interface{}{"a": "x"}}, ErrInvalidValue, 2},
+		{"a*2", []interface{}{map[string]interface{}{"a": []int{1}}}, ErrInvalidValue, 1},
+		{"10/(a-a)", []interface{}{1}, ErrDivideByZero, 3},
+		{"单价%0", []interface{}{1}, ErrDivideByZero, 3},
+		{"2^100000", nil, ErrOverflow, 2},
+	}
+	for _, c := range cases {
+		_, err := CalcE(c.input, c.val...)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("CalcE(%q) error = %v, want *Error", c.input, err)
+			continue
+		}
+		if e.Kind != c.kind || e.Pos != c.pos {
+			t.Errorf("CalcE(%q) = %v, want %v at column %d", c.input, e, c.kind, c.pos)
+		}
+	}
+
+	if got, err := CalcE("1+2*a", map[string]interface{}{"a": "1.5"}); err != nil || got != 4 {
+		t.Errorf("CalcE = %v, %v", got, err)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	RegisterFunc("Tax", func(args ...decimal.Decimal) (decimal.Decimal, error) {
+		if len(args) != 1 {
+			return decimal.Zero, &Error{Kind: ErrArgument, Msg: "tax expects 1 argument"}
+		}
+		return args[0].Mul(decimal.RequireFromString("0.13")), nil
+	})
+
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"round(2.345, 2)", "2.35"},
+		{"ROUND(2.5)", "3"},
+		{"ceil(1.01) + floor(-1.5)", "0"},
+		{"ceil(1.001, 2)", "1.01"},
+		{"max(1, 5, 3) - min(4, 2)", "3"},
+		{"sum(1, 2, 3) * avg(2, 4)", "18"},
+		{"if(a-1, 10, 1/0)", "10"},
+		{"if(0, 1/0, 20)", "20"},
+		{"tax(100)", "13"},
+		{"abs(-2) * max(a)", "4"},
+	}
+	for _, c := range cases {
+		prog, err := Compile(c.input)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", c.input, err)
+			continue
+		}
+		got, err := prog.EvalE(map[string]interface{}{"a": 2})
+		if err != nil || got.String() != c.want {
+			t.Errorf("%q = %v, %v; want %v", c.input, got, err, c.want)
+		}
+	}
+
+	for input, kind := range map[string]ErrorKind{
+		"foo(1)":    ErrUnknownFunc,
+		"if(1, 2)":  ErrArgument,
+		"round()":   ErrArgument,
+		"tax(1, 2)": ErrArgument,
+		"max(1, 2":  ErrSyntax,
+		"2(3)":      ErrSyntax,
+	} {
+		_, err := CalcE(input)
+		if e, ok := err.(*Error); !ok || e.Kind != kind {
+			t.Errorf("CalcE(%q) error = %v, want %v", input, err, kind)
+		}
+	}
+}
+
+func TestCalcBool(t *testing.T) {
+	vars := map[string]interface{}{"qty": 120, "price": 4.5, "level": 2}
+	cases := map[string]bool{
+		"qty >= 100 && price < 5":       true,
+		"qty >= 100 and price > 5":      false,
+		"qty < 100 || price <= 4.5":     true,
+		"not qty = 120":                 false,
+		"!(level <> 2) AND qty == 120":  true,
+		"level != 2 or not price > 10":  true,
+		"qty|50 = 2 && qty%50 = 20":     true,
+		"qty*price > 500 = (level > 1)": true,
+		"if(qty > 100, price, 0) = 4.5": true,
+		"level = 3 && 1/0 > 0":          false,
+		"level = 2 || 1/0 > 0":          true,
+		"qty > +1 && false":             false,
+		"+level = 2 and qty > +100":     true,
+	}
+	for input, want := range cases {
+		got, err := CalcBool(input, vars)
+		if err != nil || got != want {
+			t.Errorf("CalcBool(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+
+	if _, err := CalcBool("qty ! 1", vars); err == nil {
+		t.Error("CalcBool(\"qty ! 1\") expected error")
+	}
+}
+
+func TestValue(t *testing.T) {
+	type order struct {
+		Code    string
+		Start   time.Time
+		End     *time.Time
+		Amount  decimal.Decimal
+		Paid    bool
+		Comment sql.NullString
+		Limit   *sql.NullInt64
+	}
+	start := time.Date(2024, 1, 30, 0, 0, 0, 0, time.Local)
+	end := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	o := order{"A-01", start, &end, decimal.RequireFromString("19.99"), true, sql.NullString{}, nil}
+
+	cases := map[string]string{
+		"Code = 'A-01' and Paid":            "true",
+		"Code & '/' & Amount":               "A-01/19.99",
+		"days(End - Start)":                 "31",
+		"End - Start":                       "31.5",
+		"Start + 2":                         "2024-02-01",
+		"year(End)*100 + month(End)":        "202403",
+		"Start < '2024-02-01'":              "true",
+		"Start >= date(2024, 1, 30)":        "true",
+		"Amount * 3":                        "59.97",
+		"Comment = '' and len('单价') = 2":    "true",
+		"upper(Code) <> 'a-01'":             "true",
+		"if(Paid, 'yes', 'no')":             "yes",
+		"max('b', 'a', 'c') & min(3, 1, 2)": "c1",
+		"'it''s' & \"!\"":                   "it's!",
+		"not false and true":                "true",
+		"count(Limit, Code)":                "1",
+	}
+	for input, want := range cases {
+		prog, err := Compile(input)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", input, err)
+			continue
+		}
+		got, err := prog.EvalValue(o)
+		if err != nil || got.String() != want {
+			t.Errorf("%q = %v, %v; want %v", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"Code * 2", "Start < 1", "'abc", "date('x')"} {
+		if _, err := CalcE(input, o); err == nil {
+			t.Errorf("CalcE(%q) expected error", input)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	type Base struct {
+		ID int `json:"id"`
+	}
+	type Customer struct {
+		Base
+		Level int    `db:"cust_level"`
+		Name  string `json:"name,omitempty"`
+	}
+	type Item struct {
+		Price float64
+		Qty   int
+	}
+	type Order struct {
+		Customer *Customer
+		Items    []Item
+		Price    float64
+		Extra    map[string]interface{}
+	}
+	order := &Order{
+		Customer: &Customer{Base{7}, 3, "ACME"},
+		Items:    []Item{{2.5, 4}, {10, 1}},
+		Price:    99,
+		Extra:    map[string]interface{}{"rate": 0.5, "tags": []string{"a", "b"}},
+	}
+	vars := map[string]interface{}{"order": order, "item": Item{Price: 1, Qty: 2}, "i": 1}
+
+	cases := map[string]string{
+		"order.Customer.Level":                      "3",
+		"order.customer.cust_level * 10":            "30",
+		"order.Customer.name & order.Customer.id":   "ACME7",
+		"order.Items[0].Qty * order.Items[i].Price": "40",
+		"order.Price + item.Price":                  "100",
+		"order.Extra.rate * 2":                      "1",
+		"order.Extra['tags'][1]":                    "b",
+		"[order.Items[1-1].Price - 3]":              "0.5",
+		"Qty":                                       "2",
+	}
+	for input, want := range cases {
+		prog, err := Compile(input)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", input, err)
+			continue
+		}
+		got, err := prog.EvalValue(vars)
+		if err != nil || got.String() != want {
+			t.Errorf("%q = %v, %v; want %v", input, got, err, want)
+		}
+	}
+
+	if v, err := MustCompile("o.Customer.Level").EvalValue(map[string]interface{}{"o": &Order{}}); err != nil || !v.IsNull() {
+		t.Errorf("nil path = %v, %v; want null", v, err)
+	}
+	for input, kind := range map[string]ErrorKind{
+		"order.Missing":  ErrUnknownVar,
+		"order.Items[5]": ErrInvalidValue,
+		"order.Price.X":  ErrInvalidValue,
+		"order":          ErrInvalidValue,
+		"order.":         ErrSyntax,
+		"Price * 2":      ErrAmbiguousVar, //order与item都有Price
+	} {
+		_, err := CalcE(input, vars)
+		if e, ok := err.(*Error); !ok || e.Kind != kind {
+			t.Errorf("CalcE(%q) error = %v, want %v", input, err, kind)
+		}
+	}
+	if _, err := CalcE("Price", vars); err == nil || err.(*Error).Token != "Price" {
+		t.Errorf("ambiguous Price error = %v", err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	records := []map[string]interface{}{
+		{"Item": "A", "Amount": 10, "Rate": 1.5},
+		{"Item": "B", "Amount": 20, "Rate": 0.5},
+		{"Item": "C", "Amount": 5, "Rate": nil},
+	}
+
+	cases := map[string]string{
+		"SUM(Amount*Rate)":                  "25",
+		"count()":                           "3",
+		"COUNT(Rate)":                       "2",
+		"avg(Amount)":                       "11.6666666666666667",
+		"min(Item) & max(Amount)":           "A20",
+		"sum(Amount) * discount":            "28",
+		"round(sum(Amount)/count(), 2)":     "11.67",
+		"max(sum(Amount), 100) + sum(1, 2)": "103",
+	}
+	for input, want := range cases {
+		got, err := Aggregate(input, records, map[string]interface{}{"discount": 0.8})
+		if err != nil || got.String() != want {
+			t.Errorf("Aggregate(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+
+	if got, err := Aggregate("avg(Amount)", nil); err != nil || !got.IsNull() {
+		t.Errorf("avg of empty set = %v, %v; want null", got, err)
+	}
+	if _, err := Aggregate("sum(Item)", records); err == nil {
+		t.Error("sum(Item) expected error")
+	}
+
+	prog := MustCompile("Amount * if(Rate = 0, 1, Rate)")
+	if err := prog.AppendColumn(records, "Total"); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"15", "10", "5"} {
+		if got := records[i]["Total"].(decimal.Decimal).String(); got != want {
+			t.Errorf("record %d Total = %v, want %v", i, got, want)
+		}
+	}
+	if _, err := MustCompile("Amount / Missing").EvalRecords(records); err == nil {
+		t.Error("EvalRecords expected error for missing column")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	prog := MustCompile("price * qty")
+	vars := map[string]interface{}{"price": "2.675", "qty": 1}
+	cases := []struct {
+		opt  Options
+		want string
+	}{
+		{Options{Scale: Scale(2)}, "2.68"},
+		{Options{Scale: Scale(2), Rounding: RoundHalfEven}, "2.68"},
+		{Options{Scale: Scale(2), Rounding: RoundDown}, "2.67"},
+		{Options{Scale: Scale(1), Rounding: RoundCeiling}, "2.7"},
+		{Options{Scale: Scale(4)}, "2.6750"},
+		{Options{Scale: Scale(0)}, "3"},
+		{Options{Rounding: RoundDown}, "2.675"},
+		{Options{}, "2.675"},
+	}
+	for _, c := range cases {
+		got, err := prog.WithOptions(c.opt).EvalString(vars)
+		if err != nil || got != c.want {
+			t.Errorf("EvalString(%+v) = %v, %v; want %v", c.opt, got, err, c.want)
+		}
+	}
+
+	// 2.665的银行家舍入为2.66
+	if got, _ := CalcString("a/2", Options{Scale: Scale(2), Rounding: RoundHalfEven}, "5.33"); got != "2.66" {
+		t.Errorf("half-even = %v", got)
+	}
+	// 每步舍入与只舍入最终结果
+	if got, _ := CalcDecimal("1/3*3", Options{Scale: Scale(2), EachOp: true}); got.String() != "0.99" {
+		t.Errorf("EachOp = %v", got)
+	}
+	if got, _ := CalcDecimal("1/3*3", Options{Scale: Scale(2)}); got.String() != "1" {
+		t.Errorf("final only = %v", got)
+	}
+	if got, _ := CalcDecimal("2/3", Options{DivisionPrecision: 4}); got.String() != "0.6667" {
+		t.Errorf("DivisionPrecision = %v", got)
+	}
+	if prog.Eval(vars).String() != "2.675" {
+		t.Error("WithOptions must not change the original program")
+	}
+}
+
+func TestAST(t *testing.T) {
+	formats := []struct {
+		input string
+		want  string
+	}{
+		{"1+2*3", "1 + 2 * 3"},
+		{"(1+2)*3", "(1 + 2) * 3"},
+		{"a-(b-c)", "a - (b - c)"},
+		{"(a-b)-c", "a - b - c"},
+		{"(-2)^2", "(-2) ^ 2"},
+		{"-(a+b)", "-(a + b)"},
+		{"a && b || !c", "a and b or not c"},
+		{"NOT (a OR b)", "not (a or b)"},
+		{"a == 1 and b != 'x''y'", "a = 1 and b <> 'x''y'"},
+		{"ROUND( [x] ,2)", "round([x], 2)"},
+		{"o.Items[0].Price * 1.50", "o.Items[0].Price * 1.5"},
+	}
+	for _, tt := range formats {
+		p := MustCompile(tt.input)
+		if got := p.Format(); got != tt.want {
+			t.Errorf("Format(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		//规范格式重新编译后格式不变
+		if got := MustCompile(p.Format()).Format(); got != tt.want {
+			t.Errorf("Format(Format(%q)) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	simplifies := []struct {
+		input string
+		want  string
+	}{
+		{"price * (1 + 0.13)", "price * 1.13"},
+		{"2 * 3 + x", "6 + x"},
+		{"if(1 > 2, a, b + 2*2)", "b + 4"},
+		{"false && x", "false"},
+		{"x && true", "x and true"},
+		{"upper('a') & name", "'A' & name"},
+		{"x / (1 - 1)", "x / 0"},
+		{"1 / 0 + x", "1 / 0 + x"},
+		{"days(today() - date(2024, 1, 1))", "days(today() - date(2024, 1, 1))"},
+	}
+	for _, tt := range simplifies {
+		if got := Format(Simplify(MustCompile(tt.input).Expr())); got != tt.want {
+			t.Errorf("Simplify(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	p := MustCompile("a.b + c[d] * a + sum(e, ?)")
+	if got := Vars(p.Expr()); !reflect.DeepEqual(got, []string{"a", "c", "d", "e"}) {
+		t.Errorf("Vars = %v", got)
+	}
+	var calls []string
+	Inspect(p.Expr(), func(node Expression) bool {
+		if call, ok := node.(*CallExpression); ok {
+			calls = append(calls, call.Function)
+		}
+		return true
+	})
+	if !reflect.DeepEqual(calls, []string{"sum"}) {
+		t.Errorf("Inspect calls = %v", calls)
+	}
+}
+
+func TestScript(t *testing.T) {
+	item := map[string]interface{}{"单价": 12.5, "数量": 4, "税率_2024年": 0.13, "x1y": 2}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"单价*数量", "50"},
+		{"单价 * 数量 * (1 + 税率_2024年)", "56.5"},
+		{"x1y * 3", "6"},
+		{"a = x1y*2; b = a+1; b", "5"},
+		{"金额 = 单价*数量; 税额 = round(金额*税率_2024年, 2); 金额 + 税额;", "56.5"},
+		{"x1y = x1y + 1; x1y = x1y * 10; x1y", "30"},
+		{"a = 1; a = 2", "false"}, //最后一条语句中的"="为等于
+		{"a = 2; a = 2", "true"},
+	}
+	for _, tt := range tests {
+		v, err := MustCompile(tt.input).EvalValue(item)
+		if err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+			continue
+		}
+		if v.String() != tt.want {
+			t.Errorf("%q = %s, want %s", tt.input, v.String(), tt.want)
+		}
+	}
+
+	p := MustCompile("合计 = 单价*数量; 折扣 = if(合计 > 100, 0.9, 1); 合计*折扣 - ?")
+	if got := p.Vars(); !reflect.DeepEqual(got, []string{"单价", "数量"}) {
+		t.Errorf("Vars = %v", got)
+	}
+	if got := p.Format(); got != "合计 = 单价 * 数量; 折扣 = if(合计 > 100, 0.9, 1); 合计 * 折扣 - ?" {
+		t.Errorf("Format = %q", got)
+	}
+	if got := p.Eval(map[string]interface{}{"单价": 30, "数量": 4}, 8); got.String() != "100" {
+		t.Errorf("Eval = %s, want 100", got)
+	}
+	if got := p.Eval(map[string]interface{}{"单价": 30, "数量": 2}, 8); got.String() != "52" {
+		t.Errorf("Eval = %s, want 52", got)
+	}
+
+	records := []map[string]interface{}{{"Amount": 100}, {"Amount": 300}}
+	v, err := Aggregate("r = 0.5; SUM(Amount*r)", records)
+	if err != nil || v.String() != "200" {
+		t.Errorf("Aggregate = %v, %v", v, err)
+	}
+
+	errs := []struct {
+		input string
+		pos   int
+	}{
+		{"a + 1; b", 1},
+		{"a = 1; b = ; b", 12},
+		{"单价（1）", 3},
+		{"a = (1; a", 7},
+	}
+	for _, tt := range errs {
+		_, err := Compile(tt.input)
+		e, ok := err.(*Error)
+		if !ok || e.Kind != ErrSyntax || e.Pos != tt.pos {
+			t.Errorf("Compile(%q) error = %v, want syntax error at column %d", tt.input, err, tt.pos)
+		}
+	}
+}
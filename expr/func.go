@@ -0,0 +1,291 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 公式中可调用的数值函数。参数个数或取值有误时可返回&Error{Kind: ErrArgument}，
+// 其他错误按ErrInvalidValue处理，出错位置由调用处补充。
+type Func func(args ...decimal.Decimal) (decimal.Decimal, error)
+
+// 公式中可调用的函数，参数及返回值可以是任意类型的Value。错误处理同Func。
+type ValueFunc func(args ...Value) (Value, error)
+
+var (
+	funcMu sync.RWMutex
+	funcs  = map[string]ValueFunc{
+		"abs":   numberFunc("abs", fnAbs),
+		"sum":   numberFunc("sum", fnSum),
+		"avg":   numberFunc("avg", fnAvg),
+		"count": fnCount,
+		"round": numberFunc("round", fnRound),
+		"ceil":  numberFunc("ceil", fnCeil),
+		"floor": numberFunc("floor", fnFloor),
+		"min":   fnMin,
+		"max":   fnMax,
+		"days":  numberFunc("days", fnDays),
+		"date":  fnDate,
+		"today": fnToday,
+		"now":   fnNow,
+		"year":  datePartFunc("year", func(t time.Time) int { return t.Year() }),
+		"month": datePartFunc("month", func(t time.Time) int { return int(t.Month()) }),
+		"day":   datePartFunc("day", func(t time.Time) int { return t.Day() }),
+		"len":   stringFunc("len", func(s string) Value { return NumberValue(decimal.NewFromInt(int64(len([]rune(s))))) }),
+		"upper": stringFunc("upper", func(s string) Value { return StringValue(strings.ToUpper(s)) }),
+		"lower": stringFunc("lower", func(s string) Value { return StringValue(strings.ToLower(s)) }),
+		"trim":  stringFunc("trim", func(s string) Value { return StringValue(strings.TrimSpace(s)) }),
+	}
+)
+
+// 注册数值函数（函数名不区分大小写，同名覆盖）。须在编译用到该函数的公式之前注册。
+// if为保留函数名，不可注册。
+func RegisterFunc(name string, fn Func) {
+	if fn == nil {
+		panic("expr: RegisterFunc: nil func " + name)
+	}
+	RegisterValueFunc(name, numberFunc(name, fn))
+}
+
+// 注册参数及返回值为Value的函数，规则同RegisterFunc
+func RegisterValueFunc(name string, fn ValueFunc) {
+	name = strings.ToLower(name)
+	if name == "if" {
+		panic("expr: RegisterFunc: if is reserved")
+	}
+	if fn == nil {
+		panic("expr: RegisterFunc: nil func " + name)
+	}
+	funcMu.Lock()
+	defer funcMu.Unlock()
+	funcs[name] = fn
+}
+
+func lookupFunc(name string) ValueFunc {
+	funcMu.RLock()
+	defer funcMu.RUnlock()
+	return funcs[name]
+}
+
+func evalCallExpression(s *scope, node *CallExpression) Value {
+	//if(cond, a, b)只计算选中的分支
+	if node.Function == "if" {
+		if eval(node.Arguments[0], s).Bool() {
+			return eval(node.Arguments[1], s)
+		}
+		return eval(node.Arguments[2], s)
+	}
+
+	if s.rows != nil && isAggregate(node) {
+		return evalAggregate(s, node)
+	}
+
+	args := make([]Value, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		args[i] = eval(arg, s)
+	}
+	v, err := node.fn(args...)
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			s.error(e.Kind, node.Token, e.Msg)
+		} else {
+			s.error(ErrInvalidValue, node.Token, err.Error())
+		}
+		return NumberValue(zero)
+	}
+	return v
+}
+
+// 将数值函数包装为ValueFunc，参数须可转换为数值
+func numberFunc(name string, fn Func) ValueFunc {
+	return func(args ...Value) (Value, error) {
+		nums := make([]decimal.Decimal, len(args))
+		for i, arg := range args {
+			d, ok := arg.Decimal()
+			if !ok {
+				return NullValue, &Error{Kind: ErrInvalidValue, Msg: fmt.Sprintf("%s: argument %d is not a number", name, i+1)}
+			}
+			nums[i] = d
+		}
+		d, err := fn(nums...)
+		if err != nil {
+			return NullValue, err
+		}
+		return NumberValue(d), nil
+	}
+}
+
+func stringFunc(name string, fn func(string) Value) ValueFunc {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return NullValue, argCountError(name, "1 argument", len(args))
+		}
+		return fn(args[0].String()), nil
+	}
+}
+
+func datePartFunc(name string, fn func(time.Time) int) ValueFunc {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return NullValue, argCountError(name, "1 argument", len(args))
+		}
+		t, ok := args[0].Time()
+		if !ok {
+			return NullValue, &Error{Kind: ErrInvalidValue, Msg: fmt.Sprintf("%s: argument is not a date", name)}
+		}
+		return NumberValue(decimal.NewFromInt(int64(fn(t)))), nil
+	}
+}
+
+func argCountError(name string, want string, got int) error {
+	return &Error{Kind: ErrArgument, Msg: fmt.Sprintf("%s expects %s, got %d", name, want, got)}
+}
+
+// 小数位数参数（可省略，默认0）
+func places(name string, args []decimal.Decimal) (int32, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return 0, argCountError(name, "1 or 2 arguments", len(args))
+	}
+	if len(args) == 1 {
+		return 0, nil
+	}
+	if !args[1].IsInteger() {
+		return 0, &Error{Kind: ErrArgument, Msg: fmt.Sprintf("%s: places must be an integer", name)}
+	}
+	return int32(args[1].IntPart()), nil
+}
+
+func fnAbs(args ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(args) != 1 {
+		return zero, argCountError("abs", "1 argument", len(args))
+	}
+	return args[0].Abs(), nil
+}
+
+// min/max可比较数值、字符串或日期
+func fnMin(args ...Value) (Value, error) {
+	return extreme("min", -1, args)
+}
+
+func fnMax(args ...Value) (Value, error) {
+	return extreme("max", 1, args)
+}
+
+func extreme(name string, sign int, args []Value) (Value, error) {
+	if len(args) == 0 {
+		return NullValue, argCountError(name, "at least 1 argument", 0)
+	}
+	ret := args[0]
+	for _, arg := range args[1:] {
+		c, ok := compareValues(arg, ret)
+		if !ok {
+			return NullValue, &Error{Kind: ErrInvalidValue, Msg: fmt.Sprintf("%s: cannot compare %s with %s", name, arg.Kind(), ret.Kind())}
+		}
+		if c*sign > 0 {
+			ret = arg
+		}
+	}
+	return ret, nil
+}
+
+// count(a, b, ...) 不为空值的参数个数
+func fnCount(args ...Value) (Value, error) {
+	var n int64
+	for _, arg := range args {
+		if !arg.IsNull() {
+			n++
+		}
+	}
+	return NumberValue(decimal.NewFromInt(n)), nil
+}
+
+func fnSum(args ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(args) == 0 {
+		return zero, nil
+	}
+	return decimal.Sum(args[0], args[1:]...), nil
+}
+
+func fnAvg(args ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(args) == 0 {
+		return zero, argCountError("avg", "at least 1 argument", 0)
+	}
+	return decimal.Avg(args[0], args[1:]...), nil
+}
+
+// round(x[, n]) 四舍五入到n位小数
+func fnRound(args ...decimal.Decimal) (decimal.Decimal, error) {
+	n, err := places("round", args)
+	if err != nil {
+		return zero, err
+	}
+	return args[0].Round(n), nil
+}
+
+// ceil(x[, n]) 向上取整到n位小数
+func fnCeil(args ...decimal.Decimal) (decimal.Decimal, error) {
+	n, err := places("ceil", args)
+	if err != nil {
+		return zero, err
+	}
+	return args[0].RoundCeil(n), nil
+}
+
+// floor(x[, n]) 向下取整到n位小数
+func fnFloor(args ...decimal.Decimal) (decimal.Decimal, error) {
+	n, err := places("floor", args)
+	if err != nil {
+		return zero, err
+	}
+	return args[0].RoundFloor(n), nil
+}
+
+// days(end - start) 相差的整天数
+func fnDays(args ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(args) != 1 {
+		return zero, argCountError("days", "1 argument", len(args))
+	}
+	return args[0].Truncate(0), nil
+}
+
+// date('2024-01-31') 或 date(year, month, day)
+func fnDate(args ...Value) (Value, error) {
+	switch len(args) {
+	case 1:
+		t, ok := args[0].Time()
+		if !ok {
+			return NullValue, &Error{Kind: ErrInvalidValue, Msg: fmt.Sprintf("date: cannot parse %q", args[0].String())}
+		}
+		return DateValue(t), nil
+	case 3:
+		var ymd [3]int
+		for i, arg := range args {
+			d, ok := arg.Decimal()
+			if !ok || !d.IsInteger() {
+				return NullValue, &Error{Kind: ErrArgument, Msg: fmt.Sprintf("date: argument %d must be an integer", i+1)}
+			}
+			ymd[i] = int(d.IntPart())
+		}
+		return DateValue(time.Date(ymd[0], time.Month(ymd[1]), ymd[2], 0, 0, 0, 0, time.Local)), nil
+	}
+	return NullValue, argCountError("date", "1 or 3 arguments", len(args))
+}
+
+func fnToday(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NullValue, argCountError("today", "no arguments", len(args))
+	}
+	y, m, d := time.Now().Date()
+	return DateValue(time.Date(y, m, d, 0, 0, 0, 0, time.Local)), nil
+}
+
+func fnNow(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NullValue, argCountError("now", "no arguments", len(args))
+	}
+	return DateValue(time.Now()), nil
+}
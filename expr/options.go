@@ -0,0 +1,116 @@
+package expr
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// 舍入方式
+type RoundingMode int
+
+const (
+	RoundHalfUp   RoundingMode = iota //四舍五入（.5远离0）
+	RoundHalfEven                     //银行家舍入（四舍六入五成双）
+	RoundDown                         //截断（向0舍入）
+	RoundCeiling                      //向正无穷舍入
+	RoundFloor                        //向负无穷舍入
+	RoundUp                           //远离0舍入
+)
+
+// 计算选项，零值Options{}表示不舍入；舍入时用Scale(n)指定保留的小数位数，如Options{Scale: Scale(2)}
+type Options struct {
+	Scale    *int32       //结果保留的小数位数，nil表示不舍入
+	Rounding RoundingMode //舍入方式
+	EachOp   bool         //true=每步算术运算的结果都按Scale舍入，false=只舍入最终结果
+
+	DivisionPrecision int32 //除法保留的小数位数，0表示使用decimal.DivisionPrecision
+}
+
+// 保留n位小数，用于Options.Scale
+func Scale(n int32) *int32 {
+	return &n
+}
+
+func (m RoundingMode) round(d decimal.Decimal, scale int32) decimal.Decimal {
+	switch m {
+	case RoundHalfEven:
+		return d.RoundBank(scale)
+	case RoundDown:
+		return d.RoundDown(scale)
+	case RoundCeiling:
+		return d.RoundCeil(scale)
+	case RoundFloor:
+		return d.RoundFloor(scale)
+	case RoundUp:
+		return d.RoundUp(scale)
+	}
+	return d.Round(scale)
+}
+
+// 按选项舍入
+func (o *Options) round(d decimal.Decimal) decimal.Decimal {
+	if o == nil || o.Scale == nil {
+		return d
+	}
+	return o.Rounding.round(d, *o.Scale)
+}
+
+// 按指定的计算选项计算（返回新的Program，与原Program共用编译结果）
+func (p *Program) WithOptions(opt Options) *Program {
+	cp := *p
+	cp.opts = &opt
+	return &cp
+}
+
+// 计算公式，返回按计算选项的Scale格式化的字符串（不经过float64转换）
+func (p *Program) EvalString(val ...interface{}) (string, error) {
+	v, err := p.EvalValue(val...)
+	if err != nil {
+		return "", err
+	}
+	if v.Kind() == NumberKind && p.opts != nil && p.opts.Scale != nil {
+		return v.num.StringFixed(*p.opts.Scale), nil
+	}
+	return v.String(), nil
+}
+
+// 按计算选项计算公式，返回decimal.Decimal
+func CalcDecimal(input string, opt Options, val ...interface{}) (decimal.Decimal, error) {
+	prog, err := Compile(input)
+	if err != nil {
+		return zero, err
+	}
+	return prog.WithOptions(opt).EvalE(val...)
+}
+
+// 按计算选项计算公式，返回按Scale格式化的字符串
+func CalcString(input string, opt Options, val ...interface{}) (string, error) {
+	prog, err := Compile(input)
+	if err != nil {
+		return "", err
+	}
+	return prog.WithOptions(opt).EvalString(val...)
+}
+
+// 每步算术运算的结果
+func (s *scope) arith(d decimal.Decimal) Value {
+	if s.opts != nil && s.opts.EachOp {
+		d = s.opts.round(d)
+	}
+	return NumberValue(d)
+}
+
+func (s *scope) div(l, r decimal.Decimal) decimal.Decimal {
+	if s.opts != nil && s.opts.DivisionPrecision > 0 {
+		return l.DivRound(r, s.opts.DivisionPrecision)
+	}
+	return l.Div(r)
+}
+
+// 公式的最终结果
+func (p *Program) run(s *scope) Value {
+	v := eval(p.exp, s)
+	if v.Kind() == NumberKind && p.opts != nil {
+		v.num = p.opts.round(v.num)
+	}
+	return v
+}
@@ -0,0 +1,187 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// 计算成员/下标访问表达式的值，路径中的nil按空值处理
+func (s *scope) path(exp Expression) Value {
+	rv, ok := s.resolve(exp)
+	if !ok || !rv.IsValid() {
+		return NullValue
+	}
+	v, ok := ValueOf(rv.Interface())
+	if !ok {
+		s.error(ErrInvalidValue, pathToken(exp), fmt.Sprintf("unsupported value for %s", exp.String()))
+	}
+	return v
+}
+
+func pathToken(exp Expression) Token {
+	switch node := exp.(type) {
+	case *MemberExpression:
+		return node.Token
+	case *IndexExpression:
+		return node.Token
+	case *IdentExpression:
+		return node.Token
+	}
+	return Token{}
+}
+
+// 取路径对应的原值；返回无效的reflect.Value表示路径中遇到nil，ok为false表示出错
+func (s *scope) resolve(exp Expression) (reflect.Value, bool) {
+	switch node := exp.(type) {
+	case *IdentExpression:
+		if node.Local {
+			return reflect.ValueOf(s.local(node.Index).Interface()), true
+		}
+		if node.Index >= len(s.values) {
+			return reflect.Value{}, true
+		}
+		if b := s.values[node.Index]; b.missing || b.ambiguous {
+			s.get(node)
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(s.values[node.Index].raw), true
+
+	case *MemberExpression:
+		obj, ok := s.resolve(node.Object)
+		if obj = indirect(obj); !ok || !obj.IsValid() {
+			return obj, ok
+		}
+		switch obj.Kind() {
+		case reflect.Struct:
+			if f, ok := fieldByName(obj, node.Name); ok {
+				return f, true
+			}
+		case reflect.Map:
+			if key, ok := mapKey(obj.Type().Key(), StringValue(node.Name)); ok {
+				if v := obj.MapIndex(key); v.IsValid() {
+					return v, true
+				}
+			}
+		default:
+			s.error(ErrInvalidValue, node.Token, fmt.Sprintf("%s has no members", node.Object.String()))
+			return reflect.Value{}, false
+		}
+		s.error(ErrUnknownVar, node.Token, fmt.Sprintf("%s has no member %s", node.Object.String(), node.Name))
+		return reflect.Value{}, false
+
+	case *IndexExpression:
+		obj, ok := s.resolve(node.Left)
+		idx := eval(node.Index, s)
+		if obj = indirect(obj); !ok || !obj.IsValid() {
+			return obj, ok
+		}
+		switch obj.Kind() {
+		case reflect.Slice, reflect.Array:
+			d, ok := idx.Decimal()
+			if !ok || !d.IsInteger() {
+				s.error(ErrInvalidValue, node.Token, fmt.Sprintf("index %q is not an integer", idx.String()))
+				return reflect.Value{}, false
+			}
+			i := d.IntPart()
+			if i < 0 || i >= int64(obj.Len()) {
+				s.error(ErrInvalidValue, node.Token, fmt.Sprintf("index %d out of range [0:%d]", i, obj.Len()))
+				return reflect.Value{}, false
+			}
+			return obj.Index(int(i)), true
+		case reflect.Map:
+			if key, ok := mapKey(obj.Type().Key(), idx); ok {
+				if v := obj.MapIndex(key); v.IsValid() {
+					return v, true
+				}
+			}
+			s.error(ErrUnknownVar, node.Token, fmt.Sprintf("%s has no key %s", node.Left.String(), idx.String()))
+			return reflect.Value{}, false
+		}
+		s.error(ErrInvalidValue, node.Token, fmt.Sprintf("%s cannot be indexed", node.Left.String()))
+		return reflect.Value{}, false
+	}
+
+	v := eval(exp, s)
+	return reflect.ValueOf(v.Interface()), true
+}
+
+// 去掉指针及interface，nil返回无效的reflect.Value
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// 按Field名、json/db tag名查找struct成员（含嵌入struct的成员），找不到时再忽略大小写查找
+func fieldByName(v reflect.Value, name string) (reflect.Value, bool) {
+	if f, ok := findMember(v, name, func(a, b string) bool { return a == b }); ok {
+		return f, true
+	}
+	return findMember(v, name, strings.EqualFold)
+}
+
+func findMember(v reflect.Value, name string, match func(a, b string) bool) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || sf.Anonymous {
+			continue
+		}
+		if match(sf.Name, name) {
+			return v.Field(i), true
+		}
+		for _, tag := range tagNames(sf) {
+			if match(tag, name) {
+				return v.Field(i), true
+			}
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).Anonymous {
+			continue
+		}
+		embedded := indirect(v.Field(i))
+		if embedded.IsValid() && embedded.Kind() == reflect.Struct {
+			if f, ok := findMember(embedded, name, match); ok {
+				return f, true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// struct成员的json及db tag名
+func tagNames(sf reflect.StructField) []string {
+	var names []string
+	for _, key := range []string{"json", "db"} {
+		tag := strings.Split(sf.Tag.Get(key), ",")[0]
+		if tag != "" && tag != "-" {
+			names = append(names, tag)
+		}
+	}
+	return names
+}
+
+// 将值转换为map的key类型
+func mapKey(t reflect.Type, v Value) (reflect.Value, bool) {
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(v.String()).Convert(t), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if d, ok := v.Decimal(); ok && d.IsInteger() {
+			return reflect.ValueOf(d.IntPart()).Convert(t), true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if d, ok := v.Decimal(); ok && d.IsInteger() && !d.IsNegative() {
+			return reflect.ValueOf(uint64(d.IntPart())).Convert(t), true
+		}
+	case reflect.Interface:
+		return reflect.ValueOf(v.String()), true
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,262 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+)
+
+// Program为编译后的公式，可多次求值，并发安全（编译后不再修改）
+type Program struct {
+	input  string
+	exp    Expression
+	idents []*IdentExpression //公式中出现的变量（含?），按出现的先后次序
+	names  []string           //公式中引用的变量名（不含?，不重复）
+	locals int                //脚本中赋值的变量个数
+	opts   *Options           //计算选项，nil表示不舍入
+}
+
+// 编译公式
+func Compile(input string) (*Program, error) {
+	parser := NewParser(NewLex(input))
+	exp := parser.ParseScript()
+	if len(parser.errors) > 0 {
+		return nil, parser.errors[0]
+	}
+
+	prog := &Program{input: input, exp: exp, idents: parser.idents, locals: len(parser.locals)}
+	seen := map[string]bool{}
+	for _, ident := range parser.idents {
+		if ident.Name != "?" && !seen[ident.Name] {
+			seen[ident.Name] = true
+			prog.names = append(prog.names, ident.Name)
+		}
+	}
+	return prog, nil
+}
+
+// 同Compile，编译失败时panic
+func MustCompile(input string) *Program {
+	prog, err := Compile(input)
+	if err != nil {
+		panic(`expr: Compile(` + strconv.Quote(input) + `): ` + err.Error())
+	}
+	return prog
+}
+
+// 公式中引用的变量名（按首次出现的次序）
+func (p *Program) Vars() []string {
+	return append([]string(nil), p.names...)
+}
+
+func (p *Program) String() string {
+	return p.input
+}
+
+// 编译后的语法树，可用Walk/Inspect遍历（不可修改）
+func (p *Program) Expr() Expression {
+	return p.exp
+}
+
+// 规范化格式的公式，见Format
+func (p *Program) Format() string {
+	return Format(p.exp)
+}
+
+// 计算公式，val传参规则同Calc；结果不是数值时返回0
+func (p *Program) Eval(val ...interface{}) decimal.Decimal {
+	d, _ := p.run(p.bind(val...)).Decimal()
+	return d
+}
+
+// 同Eval，变量未赋值、变量值无效、计算出错或结果不是数值时返回*Error
+func (p *Program) EvalE(val ...interface{}) (decimal.Decimal, error) {
+	s := p.bind(val...)
+	return s.result(p.run(s))
+}
+
+// 计算公式，返回任意类型的结果（数值、字符串、布尔或日期）
+func (p *Program) EvalValue(val ...interface{}) (Value, error) {
+	s := p.bind(val...)
+	v := p.run(s)
+	if s.err != nil {
+		return NullValue, s.err
+	}
+	return v, nil
+}
+
+// 计算条件公式，结果为真(true、非0数值、非空字符串)时返回true
+func (p *Program) EvalBool(val ...interface{}) (bool, error) {
+	v, err := p.EvalValue(val...)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool(), nil
+}
+
+// 变量取值作用域（每次求值独立创建）
+type scope struct {
+	input  string
+	values []binding //按变量出现次序取值
+	err    *Error    //第1个错误
+	opts   *Options
+
+	rows   []*scope //按数据集计算聚合函数时，每条记录的取值作用域
+	locals []Value  //脚本中赋值的变量
+}
+
+func (s *scope) error(kind ErrorKind, t Token, msg string) {
+	if s.err == nil {
+		s.err = newError(kind, s.input, t, msg)
+	}
+}
+
+func (s *scope) get(ident *IdentExpression) Value {
+	if ident.Local {
+		return s.local(ident.Index)
+	}
+	if s == nil || ident.Index >= len(s.values) {
+		return NullValue
+	}
+	b := s.values[ident.Index]
+	if b.missing {
+		s.error(ErrUnknownVar, ident.Token, fmt.Sprintf("no value for %s", ident.Name))
+	} else if b.ambiguous {
+		s.error(ErrAmbiguousVar, ident.Token, fmt.Sprintf("%s is a field of more than one struct, use key.%s", ident.Name, ident.Name))
+	} else if !b.ok {
+		s.error(ErrInvalidValue, ident.Token, fmt.Sprintf("unsupported value for %s", ident.Name))
+	}
+	return b.v
+}
+
+// 取数值，不能转换为数值时记录错误并按0计算
+func (s *scope) number(t Token, v Value) decimal.Decimal {
+	d, ok := v.Decimal()
+	if !ok {
+		s.error(ErrInvalidValue, t, fmt.Sprintf("%s %q is not a number", v.Kind(), v.String()))
+	}
+	return d
+}
+
+func (s *scope) local(index int) Value {
+	if s == nil || index >= len(s.locals) {
+		return NullValue
+	}
+	return s.locals[index]
+}
+
+func (s *scope) setLocal(index int, v Value) {
+	for len(s.locals) <= index {
+		s.locals = append(s.locals, NullValue)
+	}
+	s.locals[index] = v
+}
+
+func (s *scope) result(v Value) (decimal.Decimal, error) {
+	if s.err != nil {
+		return zero, s.err
+	}
+	d, ok := v.Decimal()
+	if !ok {
+		return zero, &Error{Kind: ErrInvalidValue, Pos: 1, Msg: fmt.Sprintf("result %s %q is not a number", v.Kind(), v.String())}
+	}
+	return d, nil
+}
+
+// 按传参为公式中的变量赋值：map的key、struct的Field按名称取值，其他值按未赋值变量出现的先后次序取值
+func (p *Program) bind(val ...interface{}) *scope {
+	named := map[string]binding{}
+	var args []binding
+	for _, m := range val {
+		v := reflect.ValueOf(m)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Map {
+			//map中的struct按Field展开（兼容旧用法），key本身也可作为变量名，用于order.Price这样的成员访问；
+			//多个struct有同名Field时该变量名有歧义（map的遍历次序不定），用到时报错
+			keys := v.MapKeys()
+			flat := map[string]bool{}
+			for _, k := range keys {
+				v1 := reflect.ValueOf(v.MapIndex(k).Interface())
+				for v1.Kind() == reflect.Ptr && !v1.IsNil() {
+					v1 = v1.Elem()
+				}
+				if v1.Kind() == reflect.Struct && !isScalar(v1.Type()) {
+					fields := map[string]binding{}
+					bindStruct(fields, v1)
+					for name, b := range fields {
+						if flat[name] {
+							b = binding{ok: true, ambiguous: true}
+						}
+						flat[name] = true
+						named[name] = b
+					}
+				}
+			}
+			for _, k := range keys {
+				named[fmt.Sprint(k.Interface())] = bindValue(v.MapIndex(k).Interface())
+			}
+		} else if v.Kind() == reflect.Struct && !isScalar(v.Type()) {
+			bindStruct(named, v)
+		} else {
+			args = append(args, bindValue(m))
+		}
+	}
+
+	s := &scope{input: p.input, values: make([]binding, len(p.idents)), opts: p.opts, locals: make([]Value, p.locals)}
+	idx := 0
+	for i, ident := range p.idents {
+		value, has := named[ident.Name]
+		if !has || ident.Name == "?" {
+			if idx < len(args) {
+				value = args[idx]
+			} else {
+				value = binding{ok: true, missing: true}
+			}
+			idx++
+			if ident.Name != "?" {
+				named[ident.Name] = value
+			}
+		}
+		s.values[i] = value
+	}
+	return s
+}
+
+// 变量值，raw为传入的原值(用于成员访问)，ok为false表示原值无法直接转换为Value，
+// missing为true表示未传入该变量，ambiguous为true表示变量名有歧义（用到时才报错）
+type binding struct {
+	raw       interface{}
+	v         Value
+	ok        bool
+	missing   bool
+	ambiguous bool
+}
+
+func bindValue(i interface{}) binding {
+	v, ok := ValueOf(i)
+	return binding{raw: i, v: v, ok: ok}
+}
+
+// 按Field名展开struct，json/db tag名也可作为变量名（Field名优先）
+func bindStruct(named map[string]binding, v reflect.Value) {
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		if v.Field(n).CanInterface() {
+			named[t.Field(n).Name] = bindValue(v.Field(n).Interface())
+		}
+	}
+	for n := 0; n < t.NumField(); n++ {
+		if !v.Field(n).CanInterface() {
+			continue
+		}
+		for _, tag := range tagNames(t.Field(n)) {
+			if _, has := named[tag]; !has {
+				named[tag] = bindValue(v.Field(n).Interface())
+			}
+		}
+	}
+}
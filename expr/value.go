@@ -0,0 +1,286 @@
+package expr
+
+import (
+	"database/sql/driver"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 值类型
+type Kind int
+
+const (
+	NullKind   Kind = iota //空值
+	NumberKind             //数值(decimal.Decimal)
+	StringKind             //字符串
+	BoolKind               //布尔
+	DateKind               //日期时间(time.Time)
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NullKind:
+		return "null"
+	case NumberKind:
+		return "number"
+	case StringKind:
+		return "string"
+	case BoolKind:
+		return "bool"
+	case DateKind:
+		return "date"
+	}
+	return "unknown"
+}
+
+// 公式中的值：数值、字符串、布尔或日期
+type Value struct {
+	kind Kind
+	num  decimal.Decimal
+	str  string
+	b    bool
+	t    time.Time
+}
+
+var NullValue = Value{}
+
+func NumberValue(d decimal.Decimal) Value { return Value{kind: NumberKind, num: d} }
+func StringValue(s string) Value          { return Value{kind: StringKind, str: s} }
+func BoolValue(b bool) Value              { return Value{kind: BoolKind, b: b} }
+func DateValue(t time.Time) Value         { return Value{kind: DateKind, t: t} }
+
+func (v Value) Kind() Kind   { return v.kind }
+func (v Value) IsNull() bool { return v.kind == NullKind }
+
+// 转换为数值：布尔按1/0，空值按0，字符串须为数字格式；日期不能转换
+func (v Value) Decimal() (decimal.Decimal, bool) {
+	switch v.kind {
+	case NumberKind:
+		return v.num, true
+	case NullKind:
+		return zero, true
+	case BoolKind:
+		if v.b {
+			return trueV, true
+		}
+		return zero, true
+	case StringKind:
+		d, err := decimal.NewFromString(strings.TrimSpace(v.str))
+		return d, err == nil
+	}
+	return zero, false
+}
+
+// 转换为日期：字符串须为日期格式，空值按零时间
+func (v Value) Time() (time.Time, bool) {
+	switch v.kind {
+	case DateKind:
+		return v.t, true
+	case NullKind:
+		return time.Time{}, true
+	case StringKind:
+		return parseDate(v.str)
+	}
+	return time.Time{}, false
+}
+
+// 逻辑真假：数值不为0、字符串不为空、日期不为零值
+func (v Value) Bool() bool {
+	switch v.kind {
+	case BoolKind:
+		return v.b
+	case NumberKind:
+		return !v.num.IsZero()
+	case StringKind:
+		return v.str != ""
+	case DateKind:
+		return !v.t.IsZero()
+	}
+	return false
+}
+
+func (v Value) String() string {
+	switch v.kind {
+	case NumberKind:
+		return v.num.String()
+	case StringKind:
+		return v.str
+	case BoolKind:
+		if v.b {
+			return "true"
+		}
+		return "false"
+	case DateKind:
+		if v.t.Hour() == 0 && v.t.Minute() == 0 && v.t.Second() == 0 && v.t.Nanosecond() == 0 {
+			return v.t.Format("2006-01-02")
+		}
+		return v.t.Format("2006-01-02 15:04:05")
+	}
+	return ""
+}
+
+// 转换为Go值：nil、decimal.Decimal、string、bool或time.Time
+func (v Value) Interface() interface{} {
+	switch v.kind {
+	case NumberKind:
+		return v.num
+	case StringKind:
+		return v.str
+	case BoolKind:
+		return v.b
+	case DateKind:
+		return v.t
+	}
+	return nil
+}
+
+// 将Go值转换为Value，保留原类型；无法转换时ok为false
+func ValueOf(i interface{}) (v Value, ok bool) {
+	switch x := i.(type) {
+	case nil:
+		return NullValue, true
+	case Value:
+		return x, true
+	case decimal.Decimal:
+		return NumberValue(x), true
+	case *decimal.Decimal:
+		if x == nil {
+			return NullValue, true
+		}
+		return NumberValue(*x), true
+	case time.Time:
+		return DateValue(x), true
+	case *time.Time:
+		if x == nil {
+			return NullValue, true
+		}
+		return DateValue(*x), true
+	case []byte:
+		return StringValue(string(x)), true
+	case driver.Valuer: //sql.NullString、decimal.NullDecimal等
+		if rv := reflect.ValueOf(i); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return NullValue, true //值接收者的Value方法在nil指针上会panic
+		}
+		dv, err := x.Value()
+		if err != nil {
+			return NullValue, false
+		}
+		if _, loop := dv.(driver.Valuer); loop {
+			return NullValue, false
+		}
+		return ValueOf(dv)
+	}
+
+	rv := reflect.ValueOf(i)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return NullValue, true
+		}
+		return ValueOf(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NumberValue(decimal.NewFromInt(rv.Int())), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NumberValue(decimal.NewFromUint64(rv.Uint())), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return NullValue, false
+		}
+		if rv.Kind() == reflect.Float32 {
+			return NumberValue(decimal.NewFromFloat32(float32(f))), true
+		}
+		return NumberValue(decimal.NewFromFloat(f)), true
+	case reflect.String:
+		return StringValue(rv.String()), true
+	case reflect.Bool:
+		return BoolValue(rv.Bool()), true
+	}
+	return NullValue, false
+}
+
+// 可直接转换为Value的类型（不按struct展开字段）
+func isScalar(t reflect.Type) bool {
+	switch t {
+	case reflect.TypeOf(time.Time{}), reflect.TypeOf(decimal.Decimal{}), reflect.TypeOf(Value{}):
+		return true
+	}
+	return t.Kind() != reflect.Struct || t.Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem())
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006/01/02",
+	"2006/01/02 15:04:05",
+	"20060102",
+}
+
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+const nanosPerDay = int64(24 * time.Hour)
+
+// 日期相减的天数（可含小数）
+func daysBetween(a, b time.Time) decimal.Decimal {
+	return decimal.NewFromInt(int64(a.Sub(b))).Div(decimal.NewFromInt(nanosPerDay))
+}
+
+// 日期加减天数（可含小数）
+func addDays(t time.Time, days decimal.Decimal) time.Time {
+	whole := days.Truncate(0)
+	frac := days.Sub(whole).Mul(decimal.NewFromInt(nanosPerDay)).IntPart()
+	return t.AddDate(0, 0, int(whole.IntPart())).Add(time.Duration(frac))
+}
+
+// 比较两个值，ok为false表示类型不可比较。
+// 空值按对方类型的零值比较，数值与数字字符串、日期与日期字符串可相互比较。
+func compareValues(l, r Value) (int, bool) {
+	if l.kind == NullKind && r.kind == NullKind {
+		return 0, true
+	}
+	if l.kind == StringKind && r.kind == StringKind {
+		return strings.Compare(l.str, r.str), true
+	}
+	if l.kind == NullKind && r.kind == StringKind {
+		return strings.Compare("", r.str), true
+	}
+	if l.kind == StringKind && r.kind == NullKind {
+		return strings.Compare(l.str, ""), true
+	}
+	if l.kind == DateKind || r.kind == DateKind {
+		lt, ok1 := l.Time()
+		rt, ok2 := r.Time()
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		switch {
+		case lt.Before(rt):
+			return -1, true
+		case lt.After(rt):
+			return 1, true
+		}
+		return 0, true
+	}
+	ld, ok1 := l.Decimal()
+	rd, ok2 := r.Decimal()
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return ld.Cmp(rd), true
+}
@@ -0,0 +1,188 @@
+package filelog
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志目录中的日志文件（当前文件、按日期及按尺寸分割的文件）
+type logFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+	gz      bool //是否已压缩
+}
+
+// notify the cleaner goroutine that files were rotated or settings changed
+func (f *FileLogger) rotated() {
+	select {
+	case f.cleanChan <- struct{}{}:
+	default: //已有待处理的通知
+	}
+}
+
+// Compress rotated files and apply retention in background
+func (f *FileLogger) fileCleaner() {
+	defer f.bg.Done()
+	for {
+		select {
+		case <-f.cleanChan:
+			f.clean()
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// 压缩分割后的文件，并按保留天数、总尺寸删除旧文件（当前文件不压缩、不删除）；
+// 以前日期的文件不受fileCount限制，maxAge、maxTotal都为0（默认）时一直保留
+func (f *FileLogger) clean() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("FileLogger's Clean() catch panic: %v\n", err)
+		}
+	}()
+
+	f.mu.RLock()
+	current := filepath.Join(f.fileDir, f.fileName+f.date.Format(DATEFORMAT)+".log")
+	compress, maxAge, maxTotal := f.compress, f.maxAge, f.maxTotal
+	f.mu.RUnlock()
+
+	if compress {
+		for _, file := range f.logFiles() {
+			if !file.gz && file.path != current {
+				if err := f.compressFile(file.path); err != nil {
+					log.Printf("FileLogger compress %s: %v\n", file.path, err)
+				}
+			}
+		}
+	}
+
+	if maxAge <= 0 && maxTotal <= 0 {
+		return
+	}
+	files := f.logFiles()
+	//新的文件在前
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	cutoff := time.Now().AddDate(0, 0, -maxAge)
+	var total int64
+	for _, file := range files {
+		total += file.size
+		if file.path == current {
+			continue
+		}
+		if (maxAge > 0 && file.modTime.Before(cutoff)) || (maxTotal > 0 && total > maxTotal) {
+			os.Remove(file.path)
+			total -= file.size
+		}
+	}
+}
+
+// 日志目录中的日志文件：fileName+日期+".log"，及分割后缀".1"、".2"等，压缩后缀".gz"；
+// 同时删除压缩中途退出留下的".gz.tmp"临时文件（只在fileCleaner中调用，不会与compressFile同时运行）
+func (f *FileLogger) logFiles() []logFileInfo {
+	dir := f.fileDir
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []logFileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, f.fileName) {
+			continue
+		}
+		rest := name[len(f.fileName):]
+		i := strings.Index(rest, ".log")
+		if i < 0 {
+			continue
+		}
+		if _, err := time.Parse(DATEFORMAT, rest[:i]); err != nil {
+			continue
+		}
+		tail := rest[i+len(".log"):]
+		if strings.HasSuffix(tail, ".gz.tmp") {
+			//压缩中途退出留下的临时文件
+			os.Remove(filepath.Join(f.fileDir, name))
+			continue
+		}
+		gz := strings.HasSuffix(tail, ".gz")
+		tail = strings.TrimSuffix(tail, ".gz")
+		if tail != "" {
+			if _, err := strconv.Atoi(strings.TrimPrefix(tail, ".")); err != nil || tail[0] != '.' {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, logFileInfo{
+			path:    filepath.Join(f.fileDir, name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			gz:      gz,
+		})
+	}
+	return files
+}
+
+// gzip压缩文件为path.gz（保留修改时间）并删除原文件。
+// 压缩期间原文件被替换（按尺寸分割时后缀循环使用）时放弃本次压缩
+func (f *FileLogger) compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	//分割文件时(split)在f.mu下把当前文件改名为同一个path，检查与删除期间不能分割
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if cur, err := os.Stat(path); err != nil || !os.SameFile(info, cur) {
+		os.Remove(tmp)
+		return nil
+	}
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
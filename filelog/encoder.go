@@ -0,0 +1,200 @@
+package filelog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志条目
+type Entry struct {
+	Time    time.Time
+	Level   LEVEL  //Print、Printf、Println为OFF（无级别）
+	Prefix  string //日志前缀（SetPrefix）
+	Message string
+	Caller  string  //调用代码来源，如"main.go:12"（未记录时为""）
+	Fields  []Field //Info、Warn等的key/value参数
+
+	flushed chan struct{} //Flush的标记，写到此处时关闭
+}
+
+// 日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Encoder formats a log entry into buf, one line per entry (including the trailing newline)
+type Encoder interface {
+	Encode(buf *bytes.Buffer, e *Entry)
+}
+
+// 级别名称，OFF为""
+func (l LEVEL) String() string {
+	switch l {
+	case DEBUG:
+		return "DEBUG"
+	case TRACE:
+		return "TRACE"
+	case INFO:
+		return "INFO"
+	case WARN:
+		return "WARN"
+	case ERROR:
+		return "ERROR"
+	case PANIC:
+		return "PANIC"
+	case FATAL:
+		return "FATAL"
+	}
+	return ""
+}
+
+// 由key/value参数生成字段：key按fmt.Sprint转换，最后多出的一个参数以类型名为key
+func makeFields(args []interface{}) []Field {
+	if len(args) == 0 {
+		return nil
+	}
+	fields := make([]Field, 0, (len(args)+1)/2)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fields = append(fields, Field{Key: fmt.Sprint(args[i]), Value: args[i+1]})
+		} else {
+			fields = append(fields, Field{Key: fmt.Sprintf("%T", args[i]), Value: args[i]})
+		}
+	}
+	return fields
+}
+
+// ======================================================================================================================
+// TextEncoder is the plain text layout of the standard log.Logger:
+//
+//	prefix 2009/01/23 01:23:23.123123 [INFO] [main.go:12] message key=value
+type TextEncoder struct {
+	Flags int //Ldate、Ltime、Lmicroseconds的组合（见SetFlags），为0时不输出日期时间；Llongfile、Lshortfile被忽略
+}
+
+func (enc *TextEncoder) Encode(buf *bytes.Buffer, e *Entry) {
+	buf.WriteString(e.Prefix)
+	if enc.Flags&Ldate != 0 {
+		buf.WriteString(e.Time.Format("2006/01/02 "))
+	}
+	if enc.Flags&(Ltime|Lmicroseconds) != 0 {
+		if enc.Flags&Lmicroseconds != 0 {
+			buf.WriteString(e.Time.Format("15:04:05.000000 "))
+		} else {
+			buf.WriteString(e.Time.Format("15:04:05 "))
+		}
+	}
+	if e.Level != OFF {
+		buf.WriteString("[" + e.Level.String() + "] ")
+	}
+	if e.Caller != "" {
+		buf.WriteString("[" + e.Caller + "] ")
+	}
+	buf.WriteString(e.Message)
+	for _, field := range e.Fields {
+		fmt.Fprintf(buf, " %v=%+v", field.Key, field.Value)
+	}
+	if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+}
+
+// ======================================================================================================================
+// JSONEncoder writes one JSON object per line:
+//
+//	{"time":"2009-01-23T01:23:23.123123+08:00","level":"INFO","message":"...","caller":"main.go:12","fields":{"key":"value"}}
+//
+// level、caller、fields为空时省略，字段值不能按JSON编码时按fmt的%+v格式输出为字符串
+type JSONEncoder struct{}
+
+func (enc *JSONEncoder) Encode(buf *bytes.Buffer, e *Entry) {
+	buf.WriteString(`{"time":`)
+	writeJSON(buf, e.Time.Format(time.RFC3339Nano))
+	if e.Level != OFF {
+		buf.WriteString(`,"level":`)
+		writeJSON(buf, e.Level.String())
+	}
+	buf.WriteString(`,"message":`)
+	writeJSON(buf, strings.TrimSuffix(e.Message, "\n"))
+	if e.Caller != "" {
+		buf.WriteString(`,"caller":`)
+		writeJSON(buf, e.Caller)
+	}
+	if len(e.Fields) > 0 {
+		buf.WriteString(`,"fields":{`)
+		for i, field := range e.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSON(buf, field.Key)
+			buf.WriteByte(':')
+			writeJSON(buf, field.Value)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteString("}\n")
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%+v", v))
+	}
+	buf.Write(b)
+}
+
+// ======================================================================================================================
+// LogfmtEncoder writes logfmt key=value pairs:
+//
+//	time=2009-01-23T01:23:23.123123+08:00 level=info msg="..." caller=main.go:12 key=value
+type LogfmtEncoder struct{}
+
+func (enc *LogfmtEncoder) Encode(buf *bytes.Buffer, e *Entry) {
+	buf.WriteString("time=")
+	buf.WriteString(e.Time.Format(time.RFC3339Nano))
+	if e.Level != OFF {
+		buf.WriteString(" level=")
+		buf.WriteString(strings.ToLower(e.Level.String()))
+	}
+	buf.WriteString(" msg=")
+	writeLogfmt(buf, strings.TrimSuffix(e.Message, "\n"))
+	if e.Caller != "" {
+		buf.WriteString(" caller=")
+		writeLogfmt(buf, e.Caller)
+	}
+	for _, field := range e.Fields {
+		buf.WriteByte(' ')
+		writeLogfmt(buf, strings.Map(func(r rune) rune {
+			if r <= ' ' || r == '=' || r == '"' {
+				return '_'
+			}
+			return r
+		}, field.Key))
+		buf.WriteByte('=')
+		if err, ok := field.Value.(error); ok {
+			writeLogfmt(buf, err.Error())
+		} else {
+			writeLogfmt(buf, fmt.Sprintf("%+v", field.Value))
+		}
+	}
+	buf.WriteByte('\n')
+}
+
+// 含空白、'='、'"'或为空时加引号
+func writeLogfmt(buf *bytes.Buffer, s string) {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0 {
+		buf.WriteString(strconv.Quote(s))
+		return
+	}
+	buf.WriteString(s)
+}
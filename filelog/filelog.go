@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"path/filepath"
@@ -23,8 +24,13 @@ var (
 	DEFAULT_LOG_LEVEL         = OFF   //TRACE //默认日志级别
 	DEFAULT_LOG_CONSOLE       = false //默认是否向控制台输出
 	DEFAULT_LOG_CALLER        = false //默认是否记录调用代码
+	DEFAULT_COMPRESS          = false //默认是否压缩分割后的文件
+	DEFAULT_MAX_AGE     int   = 0     //默认日志文件保留天数(0=不限，以前日期的文件只按保留天数、总尺寸删除)
+	DEFAULT_MAX_TOTAL   int64 = 0     //默认日志文件总尺寸上限(0=不限)
 )
 
+var DEFAULT_OVERFLOW = OVERFLOW_BLOCK //默认缓存通道满时的处理方式
+
 type UNIT int64
 
 const (
@@ -35,6 +41,15 @@ const (
 	TB
 )
 
+// 缓存通道满时的处理方式
+type OVERFLOW byte
+
+const (
+	OVERFLOW_BLOCK OVERFLOW = iota //等待通道有空位
+	OVERFLOW_DROP                  //丢弃日志并计数（见Dropped）
+	OVERFLOW_SYNC                  //在调用者的goroutine中直接写入文件
+)
+
 type LEVEL byte
 
 const (
@@ -57,14 +72,28 @@ type FileLogger struct {
 	fileCount int    //最大分割文件个数(0=不限)
 	fileSize  int64  //文件分割尺寸（0=不分割）
 
+	compress  bool          //是否gzip压缩分割后的文件
+	maxAge    int           //日志文件保留天数(0=不限)
+	maxTotal  int64         //日志文件总尺寸上限(0=不限)
+	cleanChan chan struct{} //通知后台压缩、清理
+
 	date *time.Time
 
 	logFile *os.File
-	lg      *log.Logger
+	encoder Encoder //日志格式
 
 	logScan int64 //文件检查周期（秒）
 
-	logChan chan string //缓存通道
+	logChan  chan *Entry    //缓存通道
+	overflow OVERFLOW       //缓存通道满时的处理方式
+	dropped  atomic.Uint64  //丢弃的日志条数
+	chanMu   sync.RWMutex   //logChan的发送与关闭
+	writeMu  sync.Mutex     //写入日志及分割文件（logWriter、OVERFLOW_SYNC的调用者、fileMonitor）
+	closed   bool           //已关闭，不再接收日志（由chanMu保护）
+	stopped  atomic.Bool    //后台goroutine已退出，文件已关闭
+	done     chan struct{}  //通知fileMonitor、fileCleaner退出
+	wg       sync.WaitGroup //logWriter
+	bg       sync.WaitGroup //fileMonitor、fileCleaner
 
 	logLevel   LEVEL //日志级别
 	logConsole bool  //是否控制台显示
@@ -84,11 +113,18 @@ func NewDefaultLogger(fileDir, fileName, prefix string) *FileLogger {
 		prefix:     prefix,
 		suffix:     0,
 		logScan:    DEFAULT_LOG_SCAN,
-		logChan:    make(chan string, DEFAULT_LOG_SEQ),
+		logChan:    make(chan *Entry, DEFAULT_LOG_SEQ),
 		logLevel:   DEFAULT_LOG_LEVEL,
 		logConsole: DEFAULT_LOG_CONSOLE,
 		logCaller:  DEFAULT_LOG_CALLER,
 		skipCaller: 0,
+		compress:   DEFAULT_COMPRESS,
+		maxAge:     DEFAULT_MAX_AGE,
+		maxTotal:   DEFAULT_MAX_TOTAL * int64(DEFAULT_FILE_UNIT),
+		cleanChan:  make(chan struct{}, 1),
+		encoder:    &TextEncoder{Flags: LstdFlags | Lmicroseconds},
+		overflow:   DEFAULT_OVERFLOW,
+		done:       make(chan struct{}),
 	}
 
 	defaultLogger.initLogger()
@@ -115,15 +151,20 @@ func (f *FileLogger) initLogger() {
 		/*logFile := filepath.Join(f.fileDir, f.fileName+f.date.Format(DATEFORMAT)+".log")
 		if !f.isMustSplit() {
 			f.logFile, _ = os.OpenFile(logFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-			f.lg = log.New(f.logFile, f.prefix, log.LstdFlags|log.Lmicroseconds)
-		} else {
+			} else {
 			f.split()
 		}*/
 		f.fileCheck()
 	}
 
+	f.wg.Add(1)
 	go f.logWriter()
+	f.bg.Add(2)
 	go f.fileMonitor()
+	go f.fileCleaner()
+
+	//前些天遗留的文件
+	f.rotated()
 }
 
 // used for determine the fileLogger f is time to split.
@@ -165,7 +206,7 @@ func (f *FileLogger) split() {
 
 		//f.logFile, _ = os.Create(logFile)
 		f.logFile, _ = os.OpenFile(logFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-		f.lg = log.New(f.logFile, f.prefix, log.LstdFlags|log.Lmicroseconds)
+		f.rotated()
 	}
 
 	if f.isMustSplitBySize() {
@@ -178,38 +219,44 @@ func (f *FileLogger) split() {
 		if IsExist(logFileBak) {
 			os.Remove(logFileBak)
 		}
+		if IsExist(logFileBak + ".gz") {
+			os.Remove(logFileBak + ".gz")
+		}
 		os.Rename(logFile, logFileBak)
+		f.rotated()
 
 		if IsExist(logFile) {
 			f.logFile, _ = os.OpenFile(logFile, os.O_RDWR|os.O_APPEND /*|os.O_CREATE*/, 0666)
 		} else {
 			f.logFile, _ = os.Create(logFile)
 		}
-		f.lg = log.New(f.logFile, f.prefix, log.LstdFlags|log.Lmicroseconds)
 	}
 
 	if f.logFile == nil {
 		f.logFile, _ = os.OpenFile(logFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-		f.lg = log.New(f.logFile, f.prefix, log.LstdFlags|log.Lmicroseconds)
 	}
 
 }
 
 // After some interval time, goto check the current fileLogger's size or date
 func (f *FileLogger) fileMonitor() {
+	defer f.bg.Done()
 	defer func() {
 		if err := recover(); err != nil {
-			f.lg.Printf("FileLogger's FileMonitor() catch panic: %v\n", err)
+			log.Printf("FileLogger's FileMonitor() catch panic: %v\n", err)
 		}
 	}()
 
 	timer := time.NewTicker(time.Duration(f.logScan) * time.Second)
+	defer timer.Stop()
 	for {
 		select {
 		case <-timer.C:
 			if f.logLevel < OFF {
 				f.fileCheck()
 			}
+		case <-f.done:
+			return
 		}
 	}
 }
@@ -218,11 +265,24 @@ func (f *FileLogger) fileMonitor() {
 func (f *FileLogger) fileCheck() {
 	defer func() {
 		if err := recover(); err != nil {
-			f.lg.Printf("FileLogger's FileCheck() catch panic: %v\n", err)
+			log.Printf("FileLogger's FileCheck() catch panic: %v\n", err)
 		}
 	}()
 
-	if f.isMustSplit() {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	f.rotate()
+}
+
+// 需要时分割文件，调用者须持有writeMu
+func (f *FileLogger) rotate() {
+	if f.stopped.Load() {
+		return
+	}
+	f.mu.RLock()
+	must := f.isMustSplit()
+	f.mu.RUnlock()
+	if must {
 		f.mu.Lock()
 		defer f.mu.Unlock()
 
@@ -230,14 +290,31 @@ func (f *FileLogger) fileCheck() {
 	}
 }
 
-// passive to close fileLogger
+// Close writes all buffered entries, stops the background goroutines and closes the log file.
+// Entries logged after Close are dropped. Calling Close more than once returns nil.
 func (f *FileLogger) Close() error {
-	time.Sleep(time.Second)
-
+	f.chanMu.Lock()
+	if f.closed {
+		f.chanMu.Unlock()
+		return nil
+	}
+	f.closed = true
 	close(f.logChan)
-	f.lg = nil
+	f.chanMu.Unlock()
+
+	f.wg.Wait() //logWriter写完通道中的日志
+	close(f.done)
+	f.bg.Wait()
 
-	return f.logFile.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped.Store(true)
+	if f.logFile == nil {
+		return nil
+	}
+	logFile := f.logFile
+	f.logFile = nil
+	return logFile.Close()
 }
 
 // 判断文件或文件夹是否存在
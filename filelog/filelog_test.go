@@ -1,8 +1,17 @@
 package filelog
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
-	//"time"
+	"time"
 )
 
 func TestFilelog(t *testing.T) {
@@ -21,3 +30,390 @@ func TestFilelog(t *testing.T) {
 	lg.Close()
 
 }
+
+// 等待后台压缩、清理完成
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFilelogLeftover(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "log"+time.Now().AddDate(0, 0, -30).Format(DATEFORMAT)+".log")
+	yesterday := filepath.Join(dir, "log"+time.Now().AddDate(0, 0, -1).Format(DATEFORMAT)+".log")
+	other := filepath.Join(dir, "other.log")
+	for _, name := range []string{old, old + ".1", yesterday, yesterday + ".1", yesterday + ".2.gz.tmp", other} {
+		if err := os.WriteFile(name, []byte("leftover\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().AddDate(0, 0, -30)
+	os.Chtimes(old, past, past)
+	os.Chtimes(old+".1", past, past)
+
+	lg := NewDefaultLogger(dir, "log", "")
+	lg.SetMaxAge(7)
+	lg.SetCompress(true)
+	waitFor(t, "leftover files", func() bool {
+		return !IsExist(old) && !IsExist(old+".1") && IsExist(yesterday+".gz") && IsExist(yesterday+".1.gz") && !IsExist(yesterday) &&
+			!IsExist(yesterday+".2.gz.tmp")
+	})
+	if !IsExist(other) {
+		t.Fatal("unrelated file removed")
+	}
+
+	zf, err := os.Open(yesterday + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zf.Close()
+	zr, err := gzip.NewReader(zf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != "leftover\n" {
+		t.Fatalf("gzip content %q", b)
+	}
+}
+
+// 压缩与按尺寸分割同时进行：分割出的同名文件不能被压缩删除
+func TestFilelogCompressRotated(t *testing.T) {
+	dir := t.TempDir()
+	lg := NewDefaultLogger(dir, "log", "")
+	defer lg.Close()
+	backup := filepath.Join(dir, "log"+time.Now().Format(DATEFORMAT)+".log.1")
+	rotated := backup + ".new"
+	os.WriteFile(backup, []byte("old segment\n"), 0666)
+	os.WriteFile(rotated, []byte("rotated segment\n"), 0666)
+
+	lg.mu.Lock()
+	done := make(chan error)
+	go func() {
+		done <- lg.compressFile(backup)
+	}()
+	waitFor(t, "temporary file", func() bool { return IsExist(backup + ".gz.tmp") })
+	os.Rename(rotated, backup)
+	lg.mu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(backup); string(b) != "rotated segment\n" || IsExist(backup+".gz") || IsExist(backup+".gz.tmp") {
+		t.Fatalf("rotated segment lost: %q", b)
+	}
+}
+
+func TestFilelogRotateCompress(t *testing.T) {
+	dir := t.TempDir()
+	lg := NewDefaultLogger(dir, "log", "")
+	lg.SetLogLevel(INFO)
+	lg.SetMaxFileCount(3)
+	lg.SetMaxFileSize(1, KB)
+	lg.SetCompress(true)
+	current := filepath.Join(dir, "log"+time.Now().Format(DATEFORMAT)+".log")
+	for i := 0; i < 100; i++ {
+		lg.Infof("%03d %s", i, strings.Repeat("x", 80))
+	}
+	waitFor(t, "compressed backups", func() bool {
+		return IsExist(current+".1.gz") && IsExist(current+".2.gz") && IsExist(current+".3.gz") && !IsExist(current+".1")
+	})
+	if IsExist(current + ".4.gz") {
+		t.Fatal("more than fileCount backups")
+	}
+
+	lg.SetMaxTotalSize(1, KB)
+	waitFor(t, "total size", func() bool {
+		var total int64
+		for _, file := range lg.logFiles() {
+			if file.path != current {
+				total += file.size
+			}
+		}
+		return total+FileSize(current) <= 1024 || total == 0
+	})
+	if !IsExist(current) {
+		t.Fatal("current file removed")
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	e := &Entry{
+		Time:    time.Date(2009, 1, 23, 1, 23, 23, 123123000, time.UTC),
+		Level:   INFO,
+		Prefix:  "app ",
+		Message: "hello world",
+		Caller:  "main.go:12",
+		Fields:  makeFields([]interface{}{"user", "bob", "n", 1, "err", errors.New("bad thing"), 3.5}),
+	}
+	for _, tc := range []struct {
+		enc  Encoder
+		want string
+	}{
+		{&TextEncoder{Flags: LstdFlags | Lmicroseconds}, "app 2009/01/23 01:23:23.123123 [INFO] [main.go:12] hello world user=bob n=1 err=bad thing float64=3.5\n"},
+		{&JSONEncoder{}, `{"time":"2009-01-23T01:23:23.123123Z","level":"INFO","message":"hello world","caller":"main.go:12","fields":{"user":"bob","n":1,"err":"bad thing","float64":3.5}}` + "\n"},
+		{&LogfmtEncoder{}, `time=2009-01-23T01:23:23.123123Z level=info msg="hello world" caller=main.go:12 user=bob n=1 err="bad thing" float64=3.5` + "\n"},
+	} {
+		var buf bytes.Buffer
+		tc.enc.Encode(&buf, e)
+		if buf.String() != tc.want {
+			t.Errorf("%T:\n got %q\nwant %q", tc.enc, buf.String(), tc.want)
+		}
+	}
+
+	//Println的消息，无级别，不能按JSON编码的值
+	var buf bytes.Buffer
+	(&JSONEncoder{}).Encode(&buf, &Entry{Time: e.Time, Level: OFF, Message: "line\n", Fields: makeFields([]interface{}{"ch", make(chan int)})})
+	if got := buf.String(); !strings.HasPrefix(got, `{"time":"2009-01-23T01:23:23.123123Z","message":"line","fields":{"ch":"0x`) || !strings.HasSuffix(got, "\"}}\n") {
+		t.Errorf("json without level: %q", got)
+	}
+
+	//Flags为0时无日期时间，Llongfile、Lshortfile被忽略
+	for _, flags := range []int{0, Llongfile, Lshortfile} {
+		buf.Reset()
+		(&TextEncoder{Flags: flags}).Encode(&buf, e)
+		if want := "app [INFO] [main.go:12] hello world user=bob n=1 err=bad thing float64=3.5\n"; buf.String() != want {
+			t.Errorf("flags %d: got %q want %q", flags, buf.String(), want)
+		}
+	}
+
+	//SetFlags只作用于TextEncoder
+	lg := NewDefaultLogger(t.TempDir(), "log", "")
+	defer lg.Close()
+	enc := &JSONEncoder{}
+	lg.SetEncoder(enc)
+	lg.SetFlags(Ldate)
+	if lg.encoder != enc {
+		t.Errorf("SetFlags replaced the JSONEncoder")
+	}
+}
+
+func TestFilelogJSON(t *testing.T) {
+	dir := t.TempDir()
+	lg := NewDefaultLogger(dir, "log", "")
+	lg.SetLogLevel(INFO)
+	lg.SetLogCaller(true)
+	lg.SetEncoder(&JSONEncoder{})
+	lg.Info("login", "user", "bob", "id", 7)
+	lg.Debug("hidden")
+	lg.Warnf("disk %d%%", 90)
+	lg.Close()
+
+	b, err := os.ReadFile(filepath.Join(dir, "log"+time.Now().Format(DATEFORMAT)+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines %q", lines)
+	}
+	var m struct {
+		Level   string
+		Message string
+		Caller  string
+		Fields  map[string]interface{}
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Level != "INFO" || m.Message != "login" || !strings.HasPrefix(m.Caller, "filelog_test.go:") || m.Fields["user"] != "bob" || m.Fields["id"] != 7.0 {
+		t.Fatalf("%+v", m)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil || m.Level != "WARN" || m.Message != "disk 90%" {
+		t.Fatalf("%v %+v", err, m)
+	}
+}
+
+func readLines(t *testing.T, dir string) []string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, "log"+time.Now().Format(DATEFORMAT)+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestFilelogFlushClose(t *testing.T) {
+	dir := t.TempDir()
+	lg := NewDefaultLogger(dir, "log", "")
+	lg.SetLogLevel(INFO)
+	for i := 0; i < 100; i++ {
+		lg.Info("message", "i", i)
+	}
+	if err := lg.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := readLines(t, dir); len(lines) != 100 {
+		t.Fatal("after Sync", len(lines))
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				lg.Info("concurrent")
+			}
+		}()
+	}
+	wg.Wait()
+	start := time.Now()
+	if err := lg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("Close too slow", time.Since(start))
+	}
+	if lines := readLines(t, dir); len(lines) != 8100 {
+		t.Fatal("after Close", len(lines))
+	}
+
+	//关闭后丢弃，不重新打开文件
+	lg.Info("after close")
+	lg.Flush()
+	lg.SetLogLevel(DEBUG)
+	if err := lg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := readLines(t, dir); len(lines) != 8100 || lg.Dropped() != 1 {
+		t.Fatal("after second Close", len(lines), lg.Dropped())
+	}
+}
+
+func TestFilelogFlushWhileClosing(t *testing.T) {
+	dir := t.TempDir()
+	lg := NewDefaultLogger(dir, "log", "")
+	lg.SetLogLevel(INFO)
+
+	//logWriter被阻塞，Close在其他协程中等待排空通道
+	lg.mu.Lock()
+	for i := 0; i < 10; i++ {
+		lg.Info("message", "i", i)
+	}
+	closed := make(chan struct{})
+	go func() {
+		lg.Close()
+		close(closed)
+	}()
+	for {
+		lg.chanMu.RLock()
+		c := lg.closed
+		lg.chanMu.RUnlock()
+		if c {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		lg.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		t.Fatal("Flush returned before Close drained logChan")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lg.mu.Unlock()
+	<-flushed
+	if lines := readLines(t, dir); len(lines) != 10 {
+		t.Fatal("after Flush", len(lines))
+	}
+	<-closed
+}
+
+func TestFilelogOverflow(t *testing.T) {
+	for _, overflow := range []OVERFLOW{OVERFLOW_DROP, OVERFLOW_SYNC} {
+		dir := t.TempDir()
+		seq := DEFAULT_LOG_SEQ
+		DEFAULT_LOG_SEQ = 1
+		lg := NewDefaultLogger(dir, "log", "")
+		DEFAULT_LOG_SEQ = seq
+		lg.SetLogLevel(INFO)
+		lg.SetOverflow(overflow)
+
+		//logWriter被阻塞时通道很快满，OVERFLOW_SYNC时调用者也被阻塞到解锁
+		lg.mu.Lock()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 10; i++ {
+				lg.Info("overflow", "i", i)
+			}
+		}()
+		if overflow == OVERFLOW_DROP {
+			<-done
+		}
+		lg.mu.Unlock()
+		<-done
+		lg.Close()
+
+		lines := readLines(t, dir)
+		switch overflow {
+		case OVERFLOW_DROP:
+			if lg.Dropped() == 0 || len(lines)+int(lg.Dropped()) != 10 {
+				t.Fatal("drop", len(lines), lg.Dropped())
+			}
+		case OVERFLOW_SYNC:
+			if lg.Dropped() != 0 || len(lines) != 10 {
+				t.Fatal("sync", len(lines), lg.Dropped())
+			}
+		}
+	}
+}
+
+func TestFilelogSyncRotate(t *testing.T) {
+	dir := t.TempDir()
+	seq := DEFAULT_LOG_SEQ
+	DEFAULT_LOG_SEQ = 1
+	lg := NewDefaultLogger(dir, "log", "")
+	DEFAULT_LOG_SEQ = seq
+	lg.SetMaxFileSize(1, KB)
+	lg.SetMaxFileCount(10000)
+	lg.SetLogLevel(INFO)
+	lg.SetOverflow(OVERFLOW_SYNC)
+
+	//通道满时调用者与logWriter同时写入，文件不断按尺寸分割
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				lg.Info("rotate", "g", g, "i", i)
+			}
+		}(g)
+	}
+	wg.Wait()
+	lg.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "log*"))
+	if len(files) < 2 {
+		t.Fatal("no rotation", files)
+	}
+	count := 0
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			if !strings.Contains(line, "[INFO] rotate g=") || strings.Count(line, "rotate") != 1 {
+				t.Fatalf("%s: broken line %q", file, line)
+			}
+			count++
+		}
+	}
+	if count != 2000 {
+		t.Fatal("lines", count)
+	}
+}
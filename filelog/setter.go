@@ -1,6 +1,7 @@
 package filelog
 
-// Change the sizeSplit fileLogger's bak file count
+// Change the sizeSplit fileLogger's bak file count.
+// It only limits today's size-split files, files of earlier days are removed by SetMaxAge or SetMaxTotalSize
 func (f *FileLogger) SetMaxFileCount(count int) int {
 	f.fileCount = count
 	return f.fileCount
@@ -12,14 +13,56 @@ func (f *FileLogger) SetMaxFileSize(size int64, unit UNIT) int64 {
 	return f.fileSize
 }
 
+// SetCompress sets whether rotated files are gzip compressed in background, default is false
+func (f *FileLogger) SetCompress(compress bool) {
+	f.mu.Lock()
+	f.compress = compress
+	f.mu.Unlock()
+	f.rotated()
+}
+
+// SetMaxAge sets how many days log files are kept (0=unlimited).
+// With both max age and max total size 0 (default), files of earlier days are never removed
+func (f *FileLogger) SetMaxAge(days int) {
+	f.mu.Lock()
+	f.maxAge = days
+	f.mu.Unlock()
+	f.rotated()
+}
+
+// SetMaxTotalSize sets the total size limit of all log files, the oldest files are removed first (0=unlimited)
+func (f *FileLogger) SetMaxTotalSize(size int64, unit UNIT) int64 {
+	f.mu.Lock()
+	f.maxTotal = size * int64(unit)
+	f.mu.Unlock()
+	f.rotated()
+	return size * int64(unit)
+}
+
 // SetPrefix sets the output prefix for the logger.
 func (f *FileLogger) SetPrefix(prefix string) {
-	f.lg.SetPrefix(prefix)
+	f.mu.Lock()
+	f.prefix = prefix
+	f.mu.Unlock()
 }
 
-// SetFlags sets the output flags for the logger.
+// SetFlags sets the date/time flags (Ldate, Ltime, Lmicroseconds) for the TextEncoder.
+// Llongfile and Lshortfile are ignored: the caller is enabled by SetLogCaller and is always
+// written as "file.go:line". It has no effect when the encoder is a JSONEncoder or LogfmtEncoder.
 func (f *FileLogger) SetFlags(flag int) {
-	f.lg.SetFlags(flag)
+	f.mu.Lock()
+	if enc, ok := f.encoder.(*TextEncoder); ok {
+		enc.Flags = flag
+	}
+	f.mu.Unlock()
+}
+
+// SetEncoder sets the output format: &TextEncoder{Flags: LstdFlags | Lmicroseconds}(default),
+// &JSONEncoder{} or &LogfmtEncoder{}. A bare &TextEncoder{} writes no date or time.
+func (f *FileLogger) SetEncoder(enc Encoder) {
+	f.mu.Lock()
+	f.encoder = enc
+	f.mu.Unlock()
 }
 
 // SetLogSeq sets the logChan's buffer size
@@ -27,6 +70,13 @@ func (f *FileLogger) SetLogSeq(logSeq int) {
 	//TODO How to change channel buffer size when channel has data
 }
 
+// SetOverflow sets what happens when logChan is full: OVERFLOW_BLOCK(default), OVERFLOW_DROP or OVERFLOW_SYNC
+func (f *FileLogger) SetOverflow(overflow OVERFLOW) {
+	f.chanMu.Lock()
+	f.overflow = overflow
+	f.chanMu.Unlock()
+}
+
 // SetLogScanInterval sets the ticker's interval
 func (f *FileLogger) SetLogScanInterval(interval int64) {
 	//TODO How to change logScan Interval when ticker is running
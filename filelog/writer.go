@@ -1,205 +1,239 @@
 package filelog
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 )
 
-// Receive logStr from f's logChan and print logstr to file
+// Receive entries from f's logChan and print them to file, until logChan is closed and drained
 func (f *FileLogger) logWriter() {
+	defer f.wg.Done()
+
+	for e := range f.logChan {
+		if e.flushed != nil {
+			close(e.flushed)
+			continue
+		}
+		f.p(e)
+	}
+}
+
+// print log
+func (f *FileLogger) p(e *Entry) {
 	defer func() {
 		if err := recover(); err != nil {
-			if f.lg != nil { //close通道时lg也被置为了nil
-				log.Printf("FileLogger's LogWritter() catch panic: %v\n", err)
-			}
+			log.Printf("FileLogger's LogWritter() catch panic: %v\n", err)
 		}
 	}()
 
-	for {
-		select {
-		case str := <-f.logChan:
+	//分割文件与写入之间不能有其他写入，否则可能写入刚被关闭的文件
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	f.rotate()
 
-			f.p(str)
+	var buf bytes.Buffer
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	e.Prefix = f.prefix
+	f.encoder.Encode(&buf, e)
+	f.logFile.Write(buf.Bytes())
+	f.pc(buf.Bytes())
+}
+
+// 放入缓存通道，通道满时按overflow处理，关闭后丢弃
+func (f *FileLogger) send(e *Entry) {
+	f.chanMu.RLock()
+	defer f.chanMu.RUnlock()
+
+	if f.closed {
+		f.dropped.Add(1)
+		return
+	}
+	switch f.overflow {
+	case OVERFLOW_DROP:
+		select {
+		case f.logChan <- e:
+		default:
+			f.dropped.Add(1)
 		}
+	case OVERFLOW_SYNC:
+		select {
+		case f.logChan <- e:
+		default:
+			f.p(e)
+		}
+	default:
+		f.logChan <- e
 	}
 }
 
-// print log
-func (f *FileLogger) p(str string) {
-	f.fileCheck()
+// Flush blocks until all entries logged before the call are written to the log file
+func (f *FileLogger) Flush() {
+	f.chanMu.RLock()
+	if f.closed {
+		f.chanMu.RUnlock()
+		f.wg.Wait() //Close可能正在其他协程中排空通道
+		return
+	}
+	flushed := make(chan struct{})
+	f.logChan <- &Entry{flushed: flushed}
+	f.chanMu.RUnlock()
+
+	<-flushed
+}
+
+// Sync flushes the buffered entries and commits the log file to stable storage
+func (f *FileLogger) Sync() error {
+	f.Flush()
 
 	f.mu.RLock()
 	defer f.mu.RUnlock()
+	if f.logFile == nil {
+		return nil
+	}
+	return f.logFile.Sync()
+}
 
-	f.lg.Output(2, str)
-	f.pc(str)
+// Dropped returns the number of entries dropped because logChan was full (OVERFLOW_DROP) or the logger was closed
+func (f *FileLogger) Dropped() uint64 {
+	return f.dropped.Load()
 }
 
 // print log in console, default log string wont be print in console
 // NOTICE: when console is on, the process will really slowly
-func (f *FileLogger) pc(str string) {
+func (f *FileLogger) pc(line []byte) {
 	if f.logConsole {
-		if log.Prefix() != f.prefix {
-			log.SetPrefix(f.prefix)
-		}
-		log.Println(str)
+		log.Writer().Write(line)
 	}
 }
 
+// 调用代码来源，如"main.go:12"
+func caller(skip int) string {
+	_, file, line, _ := runtime.Caller(skip + 1)
+	return fmt.Sprintf("%v:%v", filepath.Base(file), line)
+}
+
 // Printf throw logstr to channel to print to the logger.
 // Arguments are handled in the manner of fmt.Printf.
 func (f *FileLogger) Printf(format string, v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1 + f.skipCaller) //calldepth=2
-	f.logChan <- fmt.Sprintf("[%v:%v] ", filepath.Base(file), line) + fmt.Sprintf(format, v...)
+	f.send(&Entry{Time: time.Now(), Level: OFF, Caller: caller(1 + f.skipCaller), Message: fmt.Sprintf(format, v...)}) //calldepth=2
 }
 
 // Print throw logstr to channel to print to the logger.
 // Arguments are handled in the manner of fmt.Print.
 func (f *FileLogger) Print(v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1 + f.skipCaller) //calldepth=2
-	f.logChan <- fmt.Sprintf("[%v:%v] ", filepath.Base(file), line) + fmt.Sprint(v...)
+	f.send(&Entry{Time: time.Now(), Level: OFF, Caller: caller(1 + f.skipCaller), Message: fmt.Sprint(v...)}) //calldepth=2
 }
 
 // Println throw logstr to channel to print to the logger.
 // Arguments are handled in the manner of fmt.Println.
 func (f *FileLogger) Println(v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1 + f.skipCaller) //calldepth=2
-	f.logChan <- fmt.Sprintf("[%v:%v] ", filepath.Base(file), line) + fmt.Sprintln(v...)
+	f.send(&Entry{Time: time.Now(), Level: OFF, Caller: caller(1 + f.skipCaller), Message: fmt.Sprintln(v...)}) //calldepth=2
 }
 
 // ======================================================================================================================
 // Debug log
 func (f *FileLogger) Debugf(format string, v ...interface{}) {
-	f.Log(DEBUG, true, format, v...)
+	f.output(DEBUG, fmt.Sprintf(format, v...), nil)
 }
 
 // same with Debug()
 func (f *FileLogger) Debug(message string, v ...interface{}) {
-	f.Log(DEBUG, false, message, v...)
+	f.output(DEBUG, message, v)
 }
 
 // Trace log
 func (f *FileLogger) Tracef(format string, v ...interface{}) {
-	f.Log(TRACE, true, format, v...)
+	f.output(TRACE, fmt.Sprintf(format, v...), nil)
 }
 
 // same with Trace()
 func (f *FileLogger) Trace(message string, v ...interface{}) {
-	f.Log(TRACE, false, message, v...)
+	f.output(TRACE, message, v)
 }
 
 // info log
 func (f *FileLogger) Infof(format string, v ...interface{}) {
-	f.Log(INFO, true, format, v...)
+	f.output(INFO, fmt.Sprintf(format, v...), nil)
 }
 
 // same with Info()
 func (f *FileLogger) Info(message string, v ...interface{}) {
-	f.Log(INFO, false, message, v...)
+	f.output(INFO, message, v)
 }
 
 // warning log
 func (f *FileLogger) Warnf(format string, v ...interface{}) {
-	f.Log(WARN, true, format, v...)
+	f.output(WARN, fmt.Sprintf(format, v...), nil)
 }
 
 // same with Warn()
 func (f *FileLogger) Warn(message string, v ...interface{}) {
-	f.Log(WARN, false, message, v...)
+	f.output(WARN, message, v)
 }
 
 // error log
 func (f *FileLogger) Errorf(format string, v ...interface{}) {
-	f.Log(ERROR, true, format, v...)
+	f.output(ERROR, fmt.Sprintf(format, v...), nil)
 }
 
 // same with Error()
 func (f *FileLogger) Error(message string, v ...interface{}) {
-	f.Log(ERROR, false, message, v...)
+	f.output(ERROR, message, v)
 }
 
 // Panic log
 func (f *FileLogger) Panicf(format string, v ...interface{}) {
-	f.Log(PANIC, true, format, v...)
-	panic(fmt.Sprintf(format, v...))
+	message := fmt.Sprintf(format, v...)
+	f.output(PANIC, message, nil)
+	f.Flush()
+	panic(message)
 }
 
 // same with Panic()
 func (f *FileLogger) Panic(message string, v ...interface{}) {
-	f.Log(PANIC, false, message, v...)
+	f.output(PANIC, message, v)
+	f.Flush()
 	panic(message)
 }
 
 // Fatal log
 func (f *FileLogger) Fatalf(format string, v ...interface{}) {
-	defer f.Close()
-	f.Log(FATAL, true, format, v...)
+	f.output(FATAL, fmt.Sprintf(format, v...), nil)
+	f.Close() //os.Exit不执行defer
 	os.Exit(1)
 }
 
 // same with Fatal()
 func (f *FileLogger) Fatal(message string, v ...interface{}) {
-	defer f.Close()
-	f.Log(FATAL, false, message, v...)
+	f.output(FATAL, message, v)
+	f.Close() //os.Exit不执行defer
 	os.Exit(1)
 }
 
-// Log 输出指定级别的日志
+// Log 输出指定级别的日志：format为true时按fmt.Sprintf格式化message，否则args为key/value字段
 func (f *FileLogger) Log(level LEVEL, format bool, message string, args ...interface{}) {
-	//fmt.Sprint(args...)
-	//fmt.Println(args)
-	//return
-	if level < f.logLevel {
-		return
+	if format {
+		f.output(level, fmt.Sprintf(message, args...), nil)
+	} else {
+		f.output(level, message, args)
 	}
-	var logLine string
-	switch level {
-	case DEBUG:
-		logLine = "[DEBUG] "
-	case TRACE:
-		logLine = "[TRACE] "
-	case INFO:
-		logLine = "[INFO] "
-	case WARN:
-		logLine = "[WARN] "
-	case ERROR:
-		logLine = "[ERROR] "
-	case FATAL:
-		logLine = "[FATAL] "
-	default:
+}
+
+// 生成日志条目并放入缓存通道，args为key/value字段
+func (f *FileLogger) output(level LEVEL, message string, args []interface{}) {
+	if level < f.logLevel || level >= OFF {
 		return
 	}
+	e := &Entry{Time: time.Now(), Level: level, Message: message, Fields: makeFields(args)}
 	if f.logCaller {
-		_, file, line, _ := runtime.Caller(2 + f.skipCaller) //calldepth
-		logLine += fmt.Sprintf("[%v:%v] ", filepath.Base(file), line)
-	}
-	if format {
-		logLine += fmt.Sprintf(message, args...)
-	} else {
-		logLine += message
-		/*for _, v := range args {
-			logLine += fmt.Sprintf(" %#v", v)
-		}*/
-		n := len(args) - 1
-		i := 0
-		for {
-			if i > n {
-				break
-			}
-			field := args[i]
-			if i <= n-1 {
-				logLine += fmt.Sprintf(" %v=%+v", fmt.Sprint(field), args[i+1])
-				i += 2
-			} else {
-				logLine += fmt.Sprintf(" %T=%+v", field, field)
-				i += 1
-			}
-		}
-
+		e.Caller = caller(2 + f.skipCaller) //calldepth
 	}
-	//fmt.Println(logLine)
-	f.logChan <- fmt.Sprint(logLine)
+	f.send(e)
 }